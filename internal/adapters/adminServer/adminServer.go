@@ -0,0 +1,122 @@
+package adminServer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// readinessTimeout bounds every readiness check.
+const readinessTimeout = 5 * time.Second
+
+// Check reports whether a dependency of the sidecar is ready.
+type Check func(ctx context.Context) error
+
+// AdminServer serves the operational endpoints of the sidecar.
+type AdminServer struct {
+	port            int
+	shutdownTimeout time.Duration
+
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+func New(port int, shutdownTimeout time.Duration) *AdminServer {
+	return &AdminServer{
+		port:            port,
+		shutdownTimeout: shutdownTimeout,
+		checks:          map[string]Check{},
+	}
+}
+
+// AddCheck registers a check that must succeed for /readyz to report ready.
+func (as *AdminServer) AddCheck(name string, check Check) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	as.checks[name] = check
+}
+
+// Listen serves until ctx is done, then shuts the server down gracefully and
+// closes the error channel.
+func (as *AdminServer) Listen(ctx context.Context) <-chan error {
+	errCh := make(chan error, 1)
+
+	e := echo.New()
+	e.HideBanner = true
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	e.GET("/healthz", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"status": "ok",
+		})
+	})
+	e.GET("/readyz", func(c echo.Context) error {
+		return c.JSON(as.ready(c.Request().Context()))
+	})
+
+	go func() {
+		if err := e.Start(fmt.Sprintf(":%d", as.port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Msg("start admin router error")
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), as.shutdownTimeout)
+		defer cancel()
+		if err := e.Shutdown(shutdownCtx); err != nil {
+			errCh <- fmt.Errorf("shutdown admin router error: %w", err)
+		}
+
+		close(errCh)
+	}()
+
+	return errCh
+}
+
+// ready runs every check concurrently and returns the response status code
+// and the result of each check.
+func (as *AdminServer) ready(ctx context.Context) (int, map[string]string) {
+	as.mu.Lock()
+	checks := make(map[string]Check, len(as.checks))
+	for name, check := range as.checks {
+		checks[name] = check
+	}
+	as.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	status := http.StatusOK
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			err := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Warn().Err(err).Str("check", name).Msg("readiness check failed")
+				results[name] = err.Error()
+				status = http.StatusServiceUnavailable
+				return
+			}
+			results[name] = "ok"
+		}(name, check)
+	}
+	wg.Wait()
+
+	return status, results
+}
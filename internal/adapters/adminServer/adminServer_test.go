@@ -0,0 +1,41 @@
+package adminServer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReady(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+
+	as := New(0, time.Second)
+	as.AddCheck("kafka", ok)
+	as.AddCheck("schema_registry", ok)
+
+	status, results := as.ready(context.Background())
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, map[string]string{"kafka": "ok", "schema_registry": "ok"}, results)
+
+	as.AddCheck("app", func(context.Context) error {
+		return errors.New("invalid response code 500, 500 Internal Server Error")
+	})
+
+	status, results = as.ready(context.Background())
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, map[string]string{
+		"kafka":           "ok",
+		"schema_registry": "ok",
+		"app":             "invalid response code 500, 500 Internal Server Error",
+	}, results)
+}
+
+func TestReadyNoChecks(t *testing.T) {
+	status, results := New(0, time.Second).ready(context.Background())
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, results)
+}
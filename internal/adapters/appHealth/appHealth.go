@@ -0,0 +1,117 @@
+package appHealth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AppHealth checks the health URL of the application the sidecar is
+// attached to.
+type AppHealth struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+
+	mu sync.Mutex
+	// ready is closed while the application is healthy.
+	ready chan struct{}
+}
+
+func New(url string, timeout time.Duration) *AppHealth {
+	return &AppHealth{
+		url:     url,
+		timeout: timeout,
+		client:  &http.Client{},
+		ready:   make(chan struct{}),
+	}
+}
+
+// Check succeeds if the health URL responds with a 2xx status code within
+// the timeout.
+func (ah *AppHealth) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ah.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ah.url, nil)
+	if err != nil {
+		return fmt.Errorf("make request error: %w", err)
+	}
+
+	resp, err := ah.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request error: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("invalid response code %d, %s", resp.StatusCode, resp.Status)
+	}
+
+	return nil
+}
+
+// Watch checks the application every interval until ctx is done. The
+// application becomes healthy on the first successful check and unhealthy
+// once checks have been failing for longer than threshold.
+func (ah *AppHealth) Watch(ctx context.Context, interval, threshold time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failingSince time.Time
+	for {
+		err := ah.Check(ctx)
+		switch {
+		case err == nil:
+			failingSince = time.Time{}
+			ah.setHealthy(true)
+		case failingSince.IsZero():
+			failingSince = time.Now()
+			log.Warn().Err(err).Msg("app health check failed")
+		case time.Since(failingSince) > threshold:
+			ah.setHealthy(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Wait blocks until the application is healthy or ctx is done.
+func (ah *AppHealth) Wait(ctx context.Context) error {
+	ah.mu.Lock()
+	ready := ah.ready
+	ah.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ready:
+		return nil
+	}
+}
+
+func (ah *AppHealth) setHealthy(healthy bool) {
+	ah.mu.Lock()
+	defer ah.mu.Unlock()
+
+	select {
+	case <-ah.ready:
+		if !healthy {
+			log.Warn().Msg("app is unhealthy, pausing consumption")
+			ah.ready = make(chan struct{})
+		}
+	default:
+		if healthy {
+			log.Info().Msg("app is healthy")
+			close(ah.ready)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package appHealth_test
+
+import (
+	"context"
+	"kafka-sidecar/internal/adapters/appHealth"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppHealthCheck(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer ts.Close()
+
+	ah := appHealth.New(ts.URL, time.Second)
+	require.NoError(t, ah.Check(context.Background()))
+
+	status.Store(http.StatusServiceUnavailable)
+	require.ErrorContains(t, ah.Check(context.Background()), "invalid response code 503")
+}
+
+func TestAppHealthWatch(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ah := appHealth.New(ts.URL, time.Second)
+	go ah.Watch(ctx, 5*time.Millisecond, 20*time.Millisecond)
+
+	// unhealthy until the first successful check
+	require.ErrorIs(t, wait(ah, 50*time.Millisecond), context.DeadlineExceeded)
+
+	status.Store(http.StatusOK)
+	require.NoError(t, wait(ah, time.Second))
+
+	// unhealthy once the checks fail past the threshold
+	status.Store(http.StatusServiceUnavailable)
+	require.Eventually(t, func() bool {
+		return wait(ah, 5*time.Millisecond) != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Wait blocks until the application is healthy again
+	done := make(chan error, 1)
+	go func() {
+		done <- ah.Wait(ctx)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned while unhealthy: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	status.Store(http.StatusOK)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return once healthy")
+	}
+}
+
+func wait(ah *appHealth.AppHealth, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return ah.Wait(ctx)
+}
@@ -2,9 +2,11 @@ package httpServer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
@@ -12,39 +14,63 @@ import (
 )
 
 type HttpServer struct {
-	port int
+	port            int
+	shutdownTimeout time.Duration
 }
 
-func New(port int) *HttpServer {
+func New(port int, shutdownTimeout time.Duration) *HttpServer {
 	return &HttpServer{
-		port: port,
+		port:            port,
+		shutdownTimeout: shutdownTimeout,
 	}
 }
 
-func (hs *HttpServer) Listen(ctx context.Context) (<-chan []byte, <-chan error) {
-	errCh := make(chan error)
-	messageCh := make(chan []byte)
+// Listen serves produce requests on / with handler and validation requests
+// on /validate/{topic} with validate until ctx is done, then shuts the server
+// down gracefully and closes the error channel.
+func (hs *HttpServer) Listen(ctx context.Context, handler func(body []byte) (int, any), validate func(topic string, body []byte) (int, any)) <-chan error {
+	errCh := make(chan error, 1)
+
+	e := echo.New()
+	e.HideBanner = true
+	e.POST("/", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Errorf("read body error: %w", err).Error(),
+			})
+		}
+
+		return c.JSON(handler(b))
+	})
+	e.POST("/validate/:topic", func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Errorf("read body error: %w", err).Error(),
+			})
+		}
+
+		return c.JSON(validate(c.Param("topic"), b))
+	})
 
 	go func() {
-		e := echo.New()
-		e.POST("/", func(c echo.Context) error {
-			b, err := io.ReadAll(c.Request().Body)
-			if err != nil {
-				err := fmt.Errorf("read body error: %w", err)
-				errCh <- err
-				return c.JSON(http.StatusInternalServerError, map[string]string{
-					"message": err.Error(),
-				})
-			}
-			err = c.NoContent(http.StatusCreated)
-			messageCh <- b
-			return err
-		})
-
-		if err := e.Start(fmt.Sprintf(":%d", hs.port)); err != nil {
+		if err := e.Start(fmt.Sprintf(":%d", hs.port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal().Err(err).Msg("start router error")
 		}
 	}()
 
-	return messageCh, errCh
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), hs.shutdownTimeout)
+		defer cancel()
+		if err := e.Shutdown(shutdownCtx); err != nil {
+			errCh <- fmt.Errorf("shutdown router error: %w", err)
+		}
+
+		close(errCh)
+	}()
+
+	return errCh
 }
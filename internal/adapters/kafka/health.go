@@ -0,0 +1,60 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Ping succeeds if at least one broker accepts a connection.
+func (k *Kafka) Ping(ctx context.Context) error {
+	var errs []error
+	for _, broker := range k.brokers {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
+		errs = append(errs, fmt.Errorf("dial broker %q error: %w", broker, err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckGroup succeeds if the consumer group is stable and every consumed
+// topic is assigned to a member of the group.
+func (k *Kafka) CheckGroup(ctx context.Context) error {
+	client := &kafka.Client{Addr: kafka.TCP(k.brokers...)}
+	resp, err := client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{
+		GroupIDs: []string{k.groupID},
+	})
+	if err != nil {
+		return fmt.Errorf("describe group %q error: %w", k.groupID, err)
+	}
+	if len(resp.Groups) == 0 {
+		return fmt.Errorf("group %q not found", k.groupID)
+	}
+
+	group := resp.Groups[0]
+	if group.Error != nil {
+		return fmt.Errorf("describe group %q error: %w", k.groupID, group.Error)
+	}
+	if group.GroupState != "Stable" {
+		return fmt.Errorf("group %q is in state %q", k.groupID, group.GroupState)
+	}
+
+	assigned := map[string]bool{}
+	for _, member := range group.Members {
+		for _, t := range member.MemberAssignments.Topics {
+			assigned[t.Topic] = true
+		}
+	}
+	for topic := range k.consumers {
+		if !assigned[topic] {
+			return fmt.Errorf("topic %q is not assigned in group %q", topic, k.groupID)
+		}
+	}
+
+	return nil
+}
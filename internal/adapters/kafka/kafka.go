@@ -2,27 +2,40 @@ package kafka
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"kafka-sidecar/internal/metrics"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
+// produceBatchTimeout is how long the producer waits for more messages before
+// writing a batch. Every message is written synchronously, so the default of
+// a second would delay each write by as much.
+const produceBatchTimeout = 10 * time.Millisecond
+
 type Kafka struct {
 	brokers   []string
+	groupID   string
 	consumers map[string]*kafka.Reader
 	producer  *kafka.Writer
+	delays    map[string]time.Duration
 }
 
 func New(brokers, topics []string, consumerGroupId string) *Kafka {
 	k := &Kafka{
 		brokers:   brokers,
+		groupID:   consumerGroupId,
 		consumers: make(map[string]*kafka.Reader, len(topics)),
+		delays:    map[string]time.Duration{},
 		producer: kafka.NewWriter(kafka.WriterConfig{
-			Brokers: brokers,
+			Brokers:      brokers,
+			BatchTimeout: produceBatchTimeout,
 		}),
 	}
+	k.producer.Completion = complete
 	for _, topic := range topics {
 		k.consumers[topic] = kafka.NewReader(kafka.ReaderConfig{
 			Brokers: brokers,
@@ -34,44 +47,107 @@ func New(brokers, topics []string, consumerGroupId string) *Kafka {
 	return k
 }
 
+// Listen fetches messages from every consumed topic until ctx is done, then
+// closes both channels.
 func (k *Kafka) Listen(ctx context.Context) (<-chan kafka.Message, <-chan error) {
-	once := sync.Once{}
+	wg := sync.WaitGroup{}
 	errCh := make(chan error)
 	messageCh := make(chan kafka.Message)
 
+	wg.Add(len(k.consumers))
 	for topic, consumer := range k.consumers {
 		go func(topic string, consumer *kafka.Reader) {
-			defer once.Do(func() {
-				close(errCh)
-				close(messageCh)
-			})
+			defer wg.Done()
 
-			for {
+			for ctx.Err() == nil {
+				m, err := consumer.FetchMessage(ctx)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					errCh <- fmt.Errorf("fetch message from topic %q error: %w", topic, err)
+					continue
+				}
+				metrics.ConsumerLag.
+					WithLabelValues(m.Topic, strconv.Itoa(m.Partition)).
+					Set(float64(m.HighWaterMark - m.Offset - 1))
+				if !k.wait(ctx, m) {
+					return
+				}
 				select {
 				case <-ctx.Done():
-					errCh <- errors.New("cancelled by context")
 					return
-				default:
-					m, err := consumer.FetchMessage(ctx)
-					if err != nil {
-						errCh <- fmt.Errorf("fetch message from topic %q error: %w", topic, err)
-					} else {
-						messageCh <- m
-					}
+				case messageCh <- m:
 				}
 			}
 		}(topic, consumer)
 	}
 
+	go func() {
+		wg.Wait()
+		close(errCh)
+		close(messageCh)
+	}()
+
 	return messageCh, errCh
 }
 
+// SetDelay makes Listen hold back every message of the topic until the delay
+// has passed since the message was produced.
+func (k *Kafka) SetDelay(topic string, delay time.Duration) {
+	k.delays[topic] = delay
+}
+
+// wait blocks until the message is due. It returns false if ctx is done first.
+func (k *Kafka) wait(ctx context.Context, m kafka.Message) bool {
+	d := time.Until(m.Time.Add(k.delays[m.Topic]))
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 func (k *Kafka) CommitMessage(ctx context.Context, m kafka.Message) error {
 	return k.consumers[m.Topic].CommitMessages(ctx, m)
 }
 
-func (k *Kafka) Send(ctx context.Context, m kafka.Message) error {
-	return k.producer.WriteMessages(ctx, m)
+// Send writes the message synchronously and returns it with the partition
+// and offset it was written to.
+func (k *Kafka) Send(ctx context.Context, m kafka.Message) (kafka.Message, error) {
+	written := &kafka.Message{}
+	m.WriterData = written
+	start := time.Now()
+	err := k.producer.WriteMessages(ctx, m)
+	metrics.ProduceDuration.WithLabelValues(m.Topic).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ProduceErrors.WithLabelValues(m.Topic).Inc()
+		return m, err
+	}
+	m.WriterData = nil
+	m.Partition, m.Offset = written.Partition, written.Offset
+
+	return m, nil
+}
+
+// complete is the producer's completion callback. It reports the partition
+// and offset of every written message back to Send through WriterData.
+func complete(messages []kafka.Message, err error) {
+	if err != nil {
+		return
+	}
+	for _, m := range messages {
+		if written, ok := m.WriterData.(*kafka.Message); ok {
+			written.Partition, written.Offset = m.Partition, m.Offset
+		}
+	}
 }
 
 func (k *Kafka) Close() error {
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riferrei/srclient"
+)
+
+// clientTimeout is the timeout of schema registry requests, as with the
+// default client of srclient.
+const clientTimeout = 5 * time.Second
+
+// Option configures how the registry authenticates to the schema registry.
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	username        string
+	password        string
+	bearerTokenFile string
+	tlsConfig       *tls.Config
+}
+
+// WithBasicAuth authenticates schema registry requests with a username and
+// a password.
+func WithBasicAuth(username, password string) Option {
+	return func(o *clientOptions) {
+		o.username, o.password = username, password
+	}
+}
+
+// WithBearerTokenFile authenticates schema registry requests with the bearer
+// token in the file. The file is read again whenever it changes, so that a
+// rotated token is picked up without a restart.
+func WithBearerTokenFile(path string) Option {
+	return func(o *clientOptions) {
+		o.bearerTokenFile = path
+	}
+}
+
+// WithTLSConfig connects to the schema registry with the TLS configuration.
+func WithTLSConfig(c *tls.Config) Option {
+	return func(o *clientOptions) {
+		o.tlsConfig = c
+	}
+}
+
+// LoadTLSConfig returns a TLS configuration trusting the CA certificates in
+// caFile in addition to the system ones, and presenting the client
+// certificate in certFile with the key in keyFile. Empty files are skipped.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(caFile) > 0 {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file error: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in ca file %s", caFile)
+		}
+		c.RootCAs = pool
+	}
+
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate error: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+// newClient creates the schema registry client with the options.
+func newClient(url string, opts ...Option) *srclient.SchemaRegistryClient {
+	o := &clientOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var clientOpts []srclient.Option
+	if o.tlsConfig != nil || len(o.bearerTokenFile) > 0 {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if o.tlsConfig != nil {
+			transport.TLSClientConfig = o.tlsConfig
+		}
+		var rt http.RoundTripper = transport
+		if len(o.bearerTokenFile) > 0 {
+			rt = &bearerTransport{path: o.bearerTokenFile, base: transport}
+		}
+		clientOpts = append(clientOpts, srclient.WithClient(&http.Client{Timeout: clientTimeout, Transport: rt}))
+	}
+
+	client := srclient.NewSchemaRegistryClient(url, clientOpts...)
+	if len(o.username) > 0 {
+		client.SetCredentials(o.username, o.password)
+	}
+
+	return client
+}
+
+// bearerTransport sets the bearer token read from a file on every request.
+// The file is read again when its modification time changes.
+type bearerTransport struct {
+	path string
+	base http.RoundTripper
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.readToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return t.base.RoundTrip(req)
+}
+
+func (t *bearerTransport) readToken() (string, error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return "", fmt.Errorf("stat bearer token file error: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.token) > 0 && info.ModTime().Equal(t.modTime) {
+		return t.token, nil
+	}
+
+	b, err := os.ReadFile(t.path)
+	if err != nil {
+		return "", fmt.Errorf("read bearer token file error: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if len(token) == 0 {
+		return "", fmt.Errorf("bearer token file %s is empty", t.path)
+	}
+	t.token, t.modTime = token, info.ModTime()
+
+	return token, nil
+}
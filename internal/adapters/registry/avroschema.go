@@ -0,0 +1,271 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// avroType is a parsed Avro schema. Named types are shared by their
+// references, so a recursive schema is a cyclic graph.
+type avroType struct {
+	// Type is a primitive type name, or record, enum, fixed, array, map or
+	// union.
+	Type    string
+	Logical string
+	// Name is the full name of records, enums and fixed types.
+	Name    string
+	Aliases []string
+
+	Fields     []*avroField
+	Symbols    []string
+	Default    interface{}
+	HasDefault bool
+	Size       int
+	// Scale is the scale of decimals.
+	Scale    int
+	Items    *avroType
+	Values   *avroType
+	Branches []*avroType
+}
+
+type avroField struct {
+	Name       string
+	Aliases    []string
+	Type       *avroType
+	Default    interface{}
+	HasDefault bool
+}
+
+var avroPrimitives = []string{"null", "boolean", "int", "long", "float", "double", "bytes", "string"}
+
+// goavroLogicalTypes are the logical types goavro names union branches by.
+var goavroLogicalTypes = []string{"long.timestamp-millis", "long.timestamp-micros", "int.time-millis", "long.time-micros", "int.date"}
+
+// parseAvroSchema parses an Avro schema definition. Defaults are decoded as
+// JSON with json.Number for numbers.
+func parseAvroSchema(definition string) (*avroType, error) {
+	dec := json.NewDecoder(strings.NewReader(definition))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("unmarshal avro schema error: %w", err)
+	}
+
+	p := avroParser{named: map[string]*avroType{}}
+	return p.parse(v, "")
+}
+
+type avroParser struct {
+	named map[string]*avroType
+}
+
+func (p *avroParser) parse(v interface{}, namespace string) (*avroType, error) {
+	switch s := v.(type) {
+	case string:
+		if isAvroPrimitive(s) {
+			return &avroType{Type: s}, nil
+		}
+		if t := p.lookup(s, namespace); t != nil {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown avro type %q", s)
+	case []interface{}:
+		t := &avroType{Type: "union"}
+		for _, item := range s {
+			b, err := p.parse(item, namespace)
+			if err != nil {
+				return nil, err
+			}
+			t.Branches = append(t.Branches, b)
+		}
+		return t, nil
+	case map[string]interface{}:
+		return p.parseMap(s, namespace)
+	default:
+		return nil, fmt.Errorf("invalid avro schema %v", v)
+	}
+}
+
+func (p *avroParser) parseMap(s map[string]interface{}, namespace string) (*avroType, error) {
+	typ, ok := s["type"].(string)
+	if !ok {
+		return p.parse(s["type"], namespace)
+	}
+	logical, _ := s["logicalType"].(string)
+
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		name, ns := avroFullName(s["name"], s["namespace"], namespace)
+		if len(name) == 0 {
+			return nil, fmt.Errorf("avro %s without a name", typ)
+		}
+		t := &avroType{Type: typ, Logical: logical, Name: name}
+		if typ == "error" {
+			t.Type = "record"
+		}
+		if aliases, ok := s["aliases"].([]interface{}); ok {
+			for _, alias := range aliases {
+				a, _ := avroFullName(alias, nil, ns)
+				t.Aliases = append(t.Aliases, a)
+			}
+		}
+		p.named[name] = t
+
+		switch t.Type {
+		case "record":
+			fields, _ := s["fields"].([]interface{})
+			for _, f := range fields {
+				field, err := p.parseField(f, ns)
+				if err != nil {
+					return nil, fmt.Errorf("record %q error: %w", name, err)
+				}
+				t.Fields = append(t.Fields, field)
+			}
+		case "enum":
+			symbols, _ := s["symbols"].([]interface{})
+			for _, symbol := range symbols {
+				sym, _ := symbol.(string)
+				t.Symbols = append(t.Symbols, sym)
+			}
+			t.Default, t.HasDefault = s["default"]
+		case "fixed":
+			size, _ := s["size"].(json.Number)
+			n, err := size.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("fixed %q without a valid size", name)
+			}
+			t.Size = int(n)
+			t.Scale = avroScale(s)
+		}
+		return t, nil
+	case "array":
+		items, err := p.parse(s["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{Type: typ, Logical: logical, Items: items}, nil
+	case "map":
+		values, err := p.parse(s["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{Type: typ, Logical: logical, Values: values}, nil
+	default:
+		t, err := p.parse(typ, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if isAvroPrimitive(t.Type) {
+			return &avroType{Type: t.Type, Logical: logical, Scale: avroScale(s)}, nil
+		}
+		return t, nil
+	}
+}
+
+func (p *avroParser) parseField(v interface{}, namespace string) (*avroField, error) {
+	f, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid field %v", v)
+	}
+
+	field := &avroField{}
+	field.Name, _ = f["name"].(string)
+	if aliases, ok := f["aliases"].([]interface{}); ok {
+		for _, alias := range aliases {
+			a, _ := alias.(string)
+			field.Aliases = append(field.Aliases, a)
+		}
+	}
+	field.Default, field.HasDefault = f["default"]
+
+	var err error
+	field.Type, err = p.parse(f["type"], namespace)
+	if err != nil {
+		return nil, fmt.Errorf("field %q error: %w", field.Name, err)
+	}
+
+	return field, nil
+}
+
+func (p *avroParser) lookup(name, namespace string) *avroType {
+	if !strings.Contains(name, ".") && len(namespace) > 0 {
+		if t := p.named[namespace+"."+name]; t != nil {
+			return t
+		}
+	}
+
+	return p.named[name]
+}
+
+// avroFullName returns the full name and the namespace of a named type.
+func avroFullName(name, namespace interface{}, enclosing string) (string, string) {
+	n, _ := name.(string)
+	if i := strings.LastIndex(n, "."); i >= 0 {
+		return n, n[:i]
+	}
+
+	ns := enclosing
+	if s, ok := namespace.(string); ok {
+		ns = s
+	}
+	if len(ns) == 0 {
+		return n, ""
+	}
+
+	return ns + "." + n, ns
+}
+
+func avroScale(s map[string]interface{}) int {
+	scale, _ := s["scale"].(json.Number)
+	n, _ := scale.Int64()
+
+	return int(n)
+}
+
+func isAvroPrimitive(name string) bool {
+	for _, p := range avroPrimitives {
+		if p == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unionName is the name goavro uses for the type as a union branch.
+func (t *avroType) unionName() string {
+	switch {
+	case len(t.Name) > 0:
+		return t.Name
+	case len(t.Logical) > 0:
+		name := t.Type + "." + t.Logical
+		for _, lt := range goavroLogicalTypes {
+			if lt == name {
+				return name
+			}
+		}
+		if name == "bytes.decimal" {
+			return name
+		}
+	}
+
+	return t.Type
+}
+
+// branch returns the union branch with the goavro name.
+func (t *avroType) branch(name string) *avroType {
+	for _, b := range t.Branches {
+		if b.unionName() == name {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// shortName is the name of a named type without its namespace.
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// completeFields fits the records of the JSON value to the schema, at any
+// depth, through arrays, maps and unions: it removes the fields the schema
+// does not define and fills missing fields with their defaults. With strict
+// it fails instead of removing fields, listing the paths of the unknown
+// fields and of the missing fields without a default. Plain JSON values are
+// not filled, as nativeFromPlain fills them with native defaults itself.
+func completeFields(t *avroType, value []byte, opts codecOptions) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("unmarshal message error: %w", err)
+	}
+
+	w := &fieldWalk{fill: !opts.PlainJSON}
+	v = w.value(t, v, "$")
+	switch {
+	case opts.StrictFields && (len(w.unknown) > 0 || len(w.missing) > 0):
+		return nil, &fieldsError{Unknown: w.unknown, Missing: w.missing}
+	case opts.ReportFields && len(w.missing) > 0:
+		return nil, &fieldsError{Missing: w.missing}
+	}
+	if !w.changed {
+		return value, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal message error: %w", err)
+	}
+
+	return b, nil
+}
+
+// fieldsError lists the JSON paths of the fields of a value that are unknown
+// to its schema and of the required fields it is missing.
+type fieldsError struct {
+	Unknown []string
+	Missing []string
+}
+
+func (e *fieldsError) Error() string {
+	var problems []string
+	if len(e.Unknown) > 0 {
+		problems = append(problems, "fields unknown to the schema: "+strings.Join(e.Unknown, ", "))
+	}
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing required fields: "+strings.Join(e.Missing, ", "))
+	}
+
+	return strings.Join(problems, "; ")
+}
+
+// fieldWalk collects the paths of unknown and missing fields.
+type fieldWalk struct {
+	fill    bool
+	unknown []string
+	missing []string
+	changed bool
+}
+
+func (w *fieldWalk) value(t *avroType, v interface{}, path string) interface{} {
+	switch t.Type {
+	case "record":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if f := t.field(k); f != nil {
+				values[k] = w.value(f.Type, values[k], path+"."+k)
+				continue
+			}
+			w.unknown = append(w.unknown, path+"."+k)
+			w.changed = true
+			delete(values, k)
+		}
+		for _, f := range t.Fields {
+			if _, ok := values[f.Name]; ok {
+				continue
+			}
+			if !f.HasDefault {
+				w.missing = append(w.missing, path+"."+f.Name)
+				continue
+			}
+			if w.fill {
+				values[f.Name] = w.value(f.Type, copyJSON(f.Default), path+"."+f.Name)
+				w.changed = true
+			}
+		}
+		return values
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		for i := range items {
+			items[i] = w.value(t.Items, items[i], fmt.Sprintf("%s[%d]", path, i))
+		}
+		return items
+	case "map":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		for k := range values {
+			values[k] = w.value(t.Values, values[k], fmt.Sprintf("%s[%q]", path, k))
+		}
+		return values
+	case "union":
+		b, inner, wrapper := unionBranchOf(t, v)
+		if b == nil {
+			return v
+		}
+		completed := w.value(b, inner, path)
+		if len(wrapper) > 0 {
+			return map[string]interface{}{wrapper: completed}
+		}
+		return completed
+	default:
+		return v
+	}
+}
+
+// unionBranchOf guesses the branch of the union a JSON value belongs to. A
+// value wrapped in an object naming its branch is unwrapped. Of several
+// record branches, the first one the value has all required fields of wins.
+func unionBranchOf(t *avroType, v interface{}) (*avroType, interface{}, string) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) == 1 {
+			for name, inner := range value {
+				if b := t.branch(name); b != nil && b.Type != "null" {
+					return b, inner, name
+				}
+			}
+		}
+
+		var candidates []*avroType
+		for _, b := range t.Branches {
+			if b.Type == "record" || b.Type == "map" {
+				candidates = append(candidates, b)
+			}
+		}
+		for _, b := range candidates {
+			if b.Type == "record" && b.hasRequiredFields(value) {
+				return b, v, ""
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[0], v, ""
+		}
+	case []interface{}:
+		for _, b := range t.Branches {
+			if b.Type == "array" {
+				return b, v, ""
+			}
+		}
+	}
+
+	return nil, v, ""
+}
+
+// copyJSON deeply copies a decoded JSON value, so that schema defaults are
+// not changed when the values they fill are completed.
+func copyJSON(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			c[k] = copyJSON(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(value))
+		for i, item := range value {
+			c[i] = copyJSON(item)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func (t *avroType) field(name string) *avroField {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// hasRequiredFields reports whether the JSON object has every field of the
+// record that has no default.
+func (t *avroType) hasRequiredFields(values map[string]interface{}) bool {
+	for _, f := range t.Fields {
+		if _, ok := values[f.Name]; !ok && !f.HasDefault {
+			return false
+		}
+	}
+
+	return true
+}
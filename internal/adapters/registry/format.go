@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Format is how the keys or the values of a topic are serialized.
+type Format string
+
+const (
+	// FormatAvro is the Confluent wire format, with the schema taken from the
+	// schema registry. Avro, JSON Schema and Protobuf subjects are supported.
+	FormatAvro Format = "avro"
+	// FormatJSON is plain JSON, passed through as is.
+	FormatJSON Format = "json"
+	// FormatString is UTF-8 text, a JSON string in the envelope.
+	FormatString Format = "string"
+	// FormatBytes is raw bytes, a base64 JSON string in the envelope.
+	FormatBytes Format = "bytes"
+)
+
+// SubjectNameStrategy selects the subject of the value schema of a topic.
+type SubjectNameStrategy string
+
+const (
+	// TopicNameStrategy uses the <topic>-value subject.
+	TopicNameStrategy SubjectNameStrategy = "topic"
+	// RecordNameStrategy uses the <record> subject.
+	RecordNameStrategy SubjectNameStrategy = "record"
+	// TopicRecordNameStrategy uses the <topic>-<record> subject.
+	TopicRecordNameStrategy SubjectNameStrategy = "topic_record"
+)
+
+// TopicConfig holds the serialization settings of a topic.
+type TopicConfig struct {
+	// KeyFormat defaults to FormatString.
+	KeyFormat Format
+	// ValueFormat defaults to FormatAvro.
+	ValueFormat Format
+	// SubjectNameStrategy defaults to TopicNameStrategy.
+	SubjectNameStrategy SubjectNameStrategy
+	// ValidateOnConsume validates consumed JSON Schema values against their
+	// schema. Produced values are always validated.
+	ValidateOnConsume bool
+	// AutoRegister allows values to be encoded with an Avro schema that is
+	// registered on first use, either Schema or the one of the request.
+	AutoRegister bool
+	Schema       string
+	// SchemaID or else SchemaVersion pins the schema values are encoded with,
+	// instead of the latest schema of the subject.
+	SchemaID      int
+	SchemaVersion int
+	// ReaderSchemaVersion, a version of the subject of the topic, or else
+	// ReaderSchema is the Avro schema consumed values are projected into.
+	ReaderSchemaVersion int
+	ReaderSchema        string
+	// PlainJSON renders Avro keys and values as plain JSON: unions are
+	// unwrapped, or inferred from the value when encoding, and logical types
+	// are rendered as strings.
+	PlainJSON bool
+	// StrictFields rejects Avro keys and values with fields unknown to the
+	// schema, which are otherwise dropped, and reports all missing required
+	// fields at once.
+	StrictFields bool
+}
+
+// valueSubject returns the subject of the value schema. Record name
+// strategies require the full name of the record.
+func (c TopicConfig) valueSubject(topic, record string) (string, error) {
+	switch c.SubjectNameStrategy {
+	case TopicNameStrategy:
+		return topic + "-value", nil
+	case RecordNameStrategy, TopicRecordNameStrategy:
+		if len(record) == 0 {
+			return "", fmt.Errorf("record name is required by the %q subject name strategy of topic %q", c.SubjectNameStrategy, topic)
+		}
+		if c.SubjectNameStrategy == RecordNameStrategy {
+			return record, nil
+		}
+		return topic + "-" + record, nil
+	default:
+		return "", fmt.Errorf("unsupported subject name strategy %q", c.SubjectNameStrategy)
+	}
+}
+
+// SetTopicConfig overrides the default settings of the topic.
+func (r *Registry) SetTopicConfig(topic string, c TopicConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.topics[topic] = c
+}
+
+func (r *Registry) topicConfig(topic string) TopicConfig {
+	r.mu.RLock()
+	c := r.topics[topic]
+	r.mu.RUnlock()
+
+	if len(c.KeyFormat) == 0 {
+		c.KeyFormat = FormatString
+	}
+	if len(c.ValueFormat) == 0 {
+		c.ValueFormat = FormatAvro
+	}
+	if len(c.SubjectNameStrategy) == 0 {
+		c.SubjectNameStrategy = TopicNameStrategy
+	}
+
+	return c
+}
+
+// EncodeKey serializes the JSON key of the topic. A missing or null key is
+// serialized as a null key.
+func (r *Registry) EncodeKey(topic string, key []byte) ([]byte, error) {
+	if len(key) == 0 || string(key) == "null" {
+		return nil, nil
+	}
+
+	c := r.topicConfig(topic)
+	if c.KeyFormat != FormatAvro {
+		return encodePlain(c.KeyFormat, key)
+	}
+
+	return r.encodeSubject(topic+"-key", key, codecOptions{
+		PlainJSON:    c.PlainJSON,
+		StrictFields: c.StrictFields,
+	})
+}
+
+// DecodeKey deserializes the key of the topic into JSON. A null key is
+// decoded as an empty string for FormatString and as null otherwise.
+func (r *Registry) DecodeKey(topic string, key []byte) ([]byte, error) {
+	c := r.topicConfig(topic)
+	if c.KeyFormat == FormatString {
+		return json.Marshal(string(key))
+	}
+	if key == nil {
+		return []byte("null"), nil
+	}
+
+	if c.KeyFormat != FormatAvro {
+		return decodePlain(c.KeyFormat, key)
+	}
+
+	return r.decodeSubject(key, codecOptions{PlainJSON: c.PlainJSON})
+}
+
+// encodePlain serializes the JSON value in a format without a schema.
+func encodePlain(f Format, value []byte) ([]byte, error) {
+	switch f {
+	case FormatJSON:
+		buf := &bytes.Buffer{}
+		if err := json.Compact(buf, value); err != nil {
+			return nil, fmt.Errorf("invalid json error: %w", err)
+		}
+		return buf.Bytes(), nil
+	case FormatString:
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, fmt.Errorf("unmarshal string error: %w", err)
+		}
+		return []byte(s), nil
+	case FormatBytes:
+		var b []byte
+		if err := json.Unmarshal(value, &b); err != nil {
+			return nil, fmt.Errorf("unmarshal base64 error: %w", err)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", f)
+	}
+}
+
+// decodePlain deserializes a value in a format without a schema into JSON.
+func decodePlain(f Format, value []byte) ([]byte, error) {
+	switch f {
+	case FormatJSON:
+		if !json.Valid(value) {
+			return nil, fmt.Errorf("value is not valid json")
+		}
+		return value, nil
+	case FormatString:
+		return json.Marshal(string(value))
+	case FormatBytes:
+		return json.Marshal(value)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", f)
+	}
+}
@@ -0,0 +1,97 @@
+package registry
+
+import (
+	"fmt"
+	"kafka-sidecar/internal/metrics"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// pinnedSchema returns the schema with the ID, or else the version of the
+// subject, and reports whether it is still the latest schema of the subject.
+func (r *Registry) pinnedSchema(topic, subject string, id, version int) (*registeredSchema, error) {
+	var schema *registeredSchema
+	var err error
+	if id > 0 {
+		schema, err = r.getSchemaByID(uint32(id))
+	} else {
+		schema, err = r.getSchemaByVersion(subject, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get pinned schema error: %w", err)
+	}
+
+	r.checkPin(topic, subject, schema)
+
+	return schema, nil
+}
+
+// checkPin sets the SchemaPinOutdated metric of the topic, and warns when the
+// pinned schema stops being the latest schema of the subject.
+func (r *Registry) checkPin(topic, subject string, pinned *registeredSchema) {
+	latest, err := r.getLatestSchema(subject)
+	if err != nil {
+		log.Debug().Err(err).Str("subject", subject).Msg("check pinned schema error")
+		return
+	}
+
+	outdated := latest.ID() != pinned.ID()
+	r.mu.Lock()
+	changed := r.outdatedPins[topic] != outdated
+	r.outdatedPins[topic] = outdated
+	r.mu.Unlock()
+
+	if outdated {
+		metrics.SchemaPinOutdated.WithLabelValues(topic).Set(1)
+	} else {
+		metrics.SchemaPinOutdated.WithLabelValues(topic).Set(0)
+	}
+	if changed && outdated {
+		log.Warn().
+			Str("topic", topic).
+			Str("subject", subject).
+			Int("pinned_id", pinned.ID()).
+			Int("latest_id", latest.ID()).
+			Msg("pinned schema is no longer the latest schema of the subject")
+	}
+}
+
+func (r *Registry) getSchemaByVersion(subject string, version int) (*registeredSchema, error) {
+	key := fmt.Sprintf("%s\x00%d", subject, version)
+
+	r.mu.RLock()
+	schema := r.versions[key]
+	r.mu.RUnlock()
+
+	if schema != nil {
+		metrics.SchemaCacheRequests.WithLabelValues("hit").Inc()
+		return schema, nil
+	}
+	metrics.SchemaCacheRequests.WithLabelValues("miss").Inc()
+
+	v, err, _ := r.group.Do("version:"+key, func() (interface{}, error) {
+		start := time.Now()
+		s, err := r.client.GetSchemaByVersion(subject, version)
+		observeFetch(start, err)
+		if err != nil {
+			return nil, requestFailed(fmt.Errorf("get version %d from subject %q error: %w", version, subject, err))
+		}
+		schema, err := r.newRegisteredSchema(s)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mu.Lock()
+		r.versions[key] = schema
+		r.byID[uint32(schema.ID())] = schema
+		r.mu.Unlock()
+
+		return schema, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*registeredSchema), nil
+}
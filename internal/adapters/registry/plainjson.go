@@ -0,0 +1,352 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	plainDateLayout       = "2006-01-02"
+	plainTimeMillisLayout = "15:04:05.000"
+	plainTimeMicrosLayout = "15:04:05.000000"
+)
+
+// plainFromNative renders a native goavro value as plain JSON: unions are
+// unwrapped and logical types are rendered as strings, timestamps in RFC
+// 3339, dates as ISO dates and decimals in full.
+func plainFromNative(t *avroType, native interface{}) ([]byte, error) {
+	v, err := plainValue(t, native)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+func plainValue(t *avroType, native interface{}) (interface{}, error) {
+	switch t.Type {
+	case "union":
+		b, inner, err := unionBranch(t, native)
+		if err != nil {
+			return nil, err
+		}
+		return plainValue(b, inner)
+	case "record":
+		values, _ := native.(map[string]interface{})
+		plain := make(map[string]interface{}, len(t.Fields))
+		for _, f := range t.Fields {
+			v, err := plainValue(f.Type, values[f.Name])
+			if err != nil {
+				return nil, fmt.Errorf("field %q error: %w", f.Name, err)
+			}
+			plain[f.Name] = v
+		}
+		return plain, nil
+	case "array":
+		items, _ := native.([]interface{})
+		plain := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if plain[i], err = plainValue(t.Items, item); err != nil {
+				return nil, err
+			}
+		}
+		return plain, nil
+	case "map":
+		values, _ := native.(map[string]interface{})
+		plain := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			var err error
+			if plain[k], err = plainValue(t.Values, value); err != nil {
+				return nil, err
+			}
+		}
+		return plain, nil
+	}
+
+	switch v := native.(type) {
+	case time.Time:
+		if t.Logical == "date" {
+			return v.UTC().Format(plainDateLayout), nil
+		}
+		return v.UTC().Format(time.RFC3339Nano), nil
+	case time.Duration:
+		layout := plainTimeMillisLayout
+		if t.Logical == "time-micros" {
+			layout = plainTimeMicrosLayout
+		}
+		return time.Time{}.Add(v).Format(layout), nil
+	case *big.Rat:
+		return v.FloatString(t.Scale), nil
+	case []byte:
+		// like the Avro JSON encoding, bytes are strings of code points 0-255
+		runes := make([]rune, len(v))
+		for i, b := range v {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case float32:
+		return plainFloat(float64(v))
+	case float64:
+		return plainFloat(v)
+	default:
+		return v, nil
+	}
+}
+
+func plainFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%v cannot be rendered as json", f)
+	}
+
+	return f, nil
+}
+
+// nativeFromPlain parses plain JSON into a native goavro value. A union
+// takes the only branch the value fits, or the branch named by a wrapper
+// object like {"string": "x"} if the value fits several branches.
+func nativeFromPlain(t *avroType, value []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("unmarshal json error: %w", err)
+	}
+
+	return nativeValue(t, v)
+}
+
+func nativeValue(t *avroType, v interface{}) (interface{}, error) {
+	switch t.Type {
+	case "union":
+		return nativeUnion(t, v)
+	case "null":
+		if v != nil {
+			return nil, fmt.Errorf("expected null, got %v", v)
+		}
+		return nil, nil
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected boolean, got %v", v)
+		}
+		return b, nil
+	case "int", "long":
+		return nativeInteger(t, v)
+	case "float", "double":
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("expected %s, got %v", t.Type, v)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		if t.Type == "float" {
+			return float32(f), nil
+		}
+		return f, nil
+	case "bytes", "fixed":
+		return nativeBytes(t, v)
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %v", v)
+		}
+		return s, nil
+	case "enum":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected symbol of enum %q, got %v", t.Name, v)
+		}
+		for _, symbol := range t.Symbols {
+			if symbol == s {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a symbol of enum %q", s, t.Name)
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected array, got %v", v)
+		}
+		native := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if native[i], err = nativeValue(t.Items, item); err != nil {
+				return nil, fmt.Errorf("item %d error: %w", i, err)
+			}
+		}
+		return native, nil
+	case "map":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected object, got %v", v)
+		}
+		native := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			var err error
+			if native[k], err = nativeValue(t.Values, value); err != nil {
+				return nil, fmt.Errorf("key %q error: %w", k, err)
+			}
+		}
+		return native, nil
+	case "record":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected record %q, got %v", t.Name, v)
+		}
+		native := make(map[string]interface{}, len(t.Fields))
+		for _, f := range t.Fields {
+			value, ok := values[f.Name]
+			if !ok {
+				if !f.HasDefault {
+					return nil, fmt.Errorf("field %q of record %q is missing", f.Name, t.Name)
+				}
+				var err error
+				if native[f.Name], err = defaultNative(f.Type, f.Default); err != nil {
+					return nil, fmt.Errorf("default of field %q error: %w", f.Name, err)
+				}
+				continue
+			}
+			var err error
+			if native[f.Name], err = nativeValue(f.Type, value); err != nil {
+				return nil, fmt.Errorf("field %q error: %w", f.Name, err)
+			}
+		}
+		return native, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q", t.Type)
+	}
+}
+
+func nativeUnion(t *avroType, v interface{}) (interface{}, error) {
+	var fits []*avroType
+	var natives []interface{}
+	for _, b := range t.Branches {
+		native, err := nativeValue(b, v)
+		if err == nil {
+			fits = append(fits, b)
+			natives = append(natives, native)
+		}
+	}
+
+	if wrapped, ok := v.(map[string]interface{}); ok && len(wrapped) == 1 && len(fits) != 1 {
+		for name, inner := range wrapped {
+			if b := t.branch(name); b != nil {
+				native, err := nativeValue(b, inner)
+				if err != nil {
+					return nil, fmt.Errorf("union branch %q error: %w", name, err)
+				}
+				return wrapUnion(b, native), nil
+			}
+		}
+	}
+
+	names := make([]string, len(t.Branches))
+	for i, b := range t.Branches {
+		names[i] = b.unionName()
+	}
+	switch len(fits) {
+	case 0:
+		return nil, fmt.Errorf("value %v fits no branch of union [%s]", v, strings.Join(names, ", "))
+	case 1:
+		return wrapUnion(fits[0], natives[0]), nil
+	default:
+		fitNames := make([]string, len(fits))
+		for i, b := range fits {
+			fitNames[i] = b.unionName()
+		}
+		return nil, fmt.Errorf(
+			"value %v is ambiguous in union [%s], it fits %s; wrap it like {%q: value}",
+			v,
+			strings.Join(names, ", "),
+			strings.Join(fitNames, " and "),
+			fitNames[0],
+		)
+	}
+}
+
+func wrapUnion(b *avroType, native interface{}) interface{} {
+	if b.Type == "null" {
+		return nil
+	}
+
+	return map[string]interface{}{b.unionName(): native}
+}
+
+func nativeInteger(t *avroType, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		switch t.Logical {
+		case "timestamp-millis", "timestamp-micros":
+			return time.Parse(time.RFC3339Nano, s)
+		case "date":
+			return time.Parse(plainDateLayout, s)
+		case "time-millis", "time-micros":
+			tod, err := time.Parse("15:04:05.999999999", s)
+			if err != nil {
+				return nil, err
+			}
+			return tod.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)), nil
+		}
+	}
+
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("expected %s, got %v", t.Type, v)
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("expected %s, got %v", t.Type, v)
+	}
+	if t.Type == "int" {
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, fmt.Errorf("%d overflows int", i)
+		}
+		return int32(i), nil
+	}
+
+	return i, nil
+}
+
+func nativeBytes(t *avroType, v interface{}) (interface{}, error) {
+	if t.Logical == "decimal" {
+		var s string
+		switch d := v.(type) {
+		case string:
+			s = d
+		case json.Number:
+			s = d.String()
+		default:
+			return nil, fmt.Errorf("expected decimal, got %v", v)
+		}
+		rat, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid decimal %q", s)
+		}
+		return rat, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected %s, got %v", t.Type, v)
+	}
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 255 {
+			return nil, fmt.Errorf("invalid %s code point %U", t.Type, r)
+		}
+		b = append(b, byte(r))
+	}
+	if t.Type == "fixed" && len(b) != t.Size {
+		return nil, fmt.Errorf("fixed %q needs %d bytes, got %d", t.Name, t.Size, len(b))
+	}
+
+	return b, nil
+}
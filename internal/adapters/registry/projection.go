@@ -0,0 +1,363 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/riferrei/srclient"
+)
+
+// avroReader is a reader schema consumed values are projected into.
+type avroReader struct {
+	Codec *goavro.Codec
+	Type  *avroType
+}
+
+// readerSchema returns the reader schema of the topic, or nil if values are
+// decoded with their writer schema.
+func (r *Registry) readerSchema(topic string, c TopicConfig) (*avroReader, error) {
+	if c.ReaderSchemaVersion > 0 {
+		subject, err := c.valueSubject(topic, "")
+		if err != nil {
+			return nil, err
+		}
+		schema, err := r.getSchemaByVersion(subject, c.ReaderSchemaVersion)
+		if err != nil {
+			return nil, fmt.Errorf("get reader schema error: %w", err)
+		}
+		if schema.Type != srclient.Avro {
+			return nil, fmt.Errorf("reader schema of topic %q is not an avro schema", topic)
+		}
+		return &avroReader{schema.Decoder, schema.AvroType}, nil
+	}
+	if len(c.ReaderSchema) == 0 {
+		return nil, nil
+	}
+
+	r.mu.RLock()
+	reader := r.readers[topic]
+	r.mu.RUnlock()
+	if reader != nil {
+		return reader, nil
+	}
+
+	codec, err := goavro.NewCodecForStandardJSONFull(c.ReaderSchema)
+	if err != nil {
+		return nil, fmt.Errorf("create reader codec of topic %q error: %w", topic, err)
+	}
+	t, err := parseAvroSchema(c.ReaderSchema)
+	if err != nil {
+		return nil, fmt.Errorf("parse reader schema of topic %q error: %w", topic, err)
+	}
+	reader = &avroReader{codec, t}
+
+	r.mu.Lock()
+	r.readers[topic] = reader
+	r.mu.Unlock()
+
+	return reader, nil
+}
+
+// project resolves a native goavro value written with the writer schema into
+// the reader schema, following the Avro schema resolution rules: fields
+// unknown to the reader are dropped, fields unknown to the writer take their
+// default and numbers are promoted.
+func project(w, r *avroType, v interface{}) (interface{}, error) {
+	if w.Type == "union" {
+		wb, inner, err := unionBranch(w, v)
+		if err != nil {
+			return nil, err
+		}
+		return project(wb, r, inner)
+	}
+
+	if r.Type == "union" {
+		for _, rb := range r.Branches {
+			if !avroMatches(w, rb) {
+				continue
+			}
+			if rb.Type == "null" {
+				return nil, nil
+			}
+			projected, err := project(w, rb, v)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{rb.unionName(): projected}, nil
+		}
+		return nil, fmt.Errorf("no branch of the reader union matches writer type %q", w.unionName())
+	}
+
+	if !avroMatches(w, r) {
+		return nil, fmt.Errorf("writer type %q does not match reader type %q", w.unionName(), r.unionName())
+	}
+
+	switch r.Type {
+	case "record":
+		return projectRecord(w, r, v)
+	case "enum":
+		symbol, _ := v.(string)
+		for _, s := range r.Symbols {
+			if s == symbol {
+				return symbol, nil
+			}
+		}
+		if r.HasDefault {
+			return r.Default, nil
+		}
+		return nil, fmt.Errorf("symbol %q is unknown to reader enum %q", symbol, r.Name)
+	case "array":
+		items, _ := v.([]interface{})
+		projected := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if projected[i], err = project(w.Items, r.Items, item); err != nil {
+				return nil, err
+			}
+		}
+		return projected, nil
+	case "map":
+		values, _ := v.(map[string]interface{})
+		projected := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			var err error
+			if projected[k], err = project(w.Values, r.Values, value); err != nil {
+				return nil, err
+			}
+		}
+		return projected, nil
+	default:
+		return promote(v, r.Type), nil
+	}
+}
+
+func projectRecord(w, r *avroType, v interface{}) (interface{}, error) {
+	values, _ := v.(map[string]interface{})
+	projected := make(map[string]interface{}, len(r.Fields))
+	for _, rf := range r.Fields {
+		wf := writerField(w, rf)
+		if wf != nil {
+			value, err := project(wf.Type, rf.Type, values[wf.Name])
+			if err != nil {
+				return nil, fmt.Errorf("field %q error: %w", rf.Name, err)
+			}
+			projected[rf.Name] = value
+			continue
+		}
+
+		if !rf.HasDefault {
+			return nil, fmt.Errorf("field %q of reader record %q is missing from the writer schema and has no default", rf.Name, r.Name)
+		}
+		value, err := defaultNative(rf.Type, rf.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default of field %q error: %w", rf.Name, err)
+		}
+		projected[rf.Name] = value
+	}
+
+	return projected, nil
+}
+
+// writerField returns the writer field with the name or an alias of the
+// reader field.
+func writerField(w *avroType, rf *avroField) *avroField {
+	for _, wf := range w.Fields {
+		if wf.Name == rf.Name {
+			return wf
+		}
+	}
+	for _, wf := range w.Fields {
+		for _, alias := range rf.Aliases {
+			if wf.Name == alias {
+				return wf
+			}
+		}
+	}
+
+	return nil
+}
+
+// unionBranch returns the branch of the union a native value was written
+// with, and the value without its union wrapper.
+func unionBranch(t *avroType, v interface{}) (*avroType, interface{}, error) {
+	if v == nil {
+		if b := t.branch("null"); b != nil {
+			return b, nil, nil
+		}
+		return nil, nil, fmt.Errorf("null value for union without a null branch")
+	}
+
+	wrapped, ok := v.(map[string]interface{})
+	if !ok || len(wrapped) != 1 {
+		return nil, nil, fmt.Errorf("invalid union value %v", v)
+	}
+	for name, inner := range wrapped {
+		if b := t.branch(name); b != nil {
+			return b, inner, nil
+		}
+		return nil, nil, fmt.Errorf("unknown union branch %q", name)
+	}
+
+	return nil, nil, nil
+}
+
+// avroMatches reports whether a value of the writer type can be read as the
+// reader type.
+func avroMatches(w, r *avroType) bool {
+	switch {
+	case w.Type == r.Type && isAvroPrimitive(r.Type):
+		return true
+	case w.Type == "int":
+		return r.Type == "long" || r.Type == "float" || r.Type == "double"
+	case w.Type == "long":
+		return r.Type == "float" || r.Type == "double"
+	case w.Type == "float":
+		return r.Type == "double"
+	case w.Type == "string":
+		return r.Type == "bytes"
+	case w.Type == "bytes":
+		return r.Type == "string"
+	case w.Type != r.Type:
+		return false
+	case r.Type == "array", r.Type == "map":
+		return true
+	case r.Type == "fixed" && w.Size != r.Size:
+		return false
+	}
+
+	if shortName(w.Name) == shortName(r.Name) {
+		return true
+	}
+	for _, alias := range r.Aliases {
+		if alias == w.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// promote converts a native primitive to the native type of the reader.
+func promote(v interface{}, to string) interface{} {
+	switch n := v.(type) {
+	case int32:
+		switch to {
+		case "long":
+			return int64(n)
+		case "float":
+			return float32(n)
+		case "double":
+			return float64(n)
+		}
+	case int64:
+		switch to {
+		case "float":
+			return float32(n)
+		case "double":
+			return float64(n)
+		}
+	case float32:
+		if to == "double" {
+			return float64(n)
+		}
+	case string:
+		if to == "bytes" {
+			return []byte(n)
+		}
+	case []byte:
+		if to == "string" {
+			return string(n)
+		}
+	}
+
+	return v
+}
+
+// defaultNative converts the JSON default of a field into a native goavro
+// value of its type. The default of a union is of its first branch.
+func defaultNative(t *avroType, d interface{}) (interface{}, error) {
+	switch t.Type {
+	case "null":
+		return nil, nil
+	case "boolean", "string", "enum":
+		return d, nil
+	case "int", "long", "float", "double":
+		n, ok := d.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s default %v", t.Type, d)
+		}
+		switch t.Type {
+		case "int":
+			i, err := n.Int64()
+			return int32(i), err
+		case "long":
+			return n.Int64()
+		case "float":
+			f, err := n.Float64()
+			return float32(f), err
+		default:
+			return n.Float64()
+		}
+	case "bytes", "fixed":
+		// bytes defaults are strings of code points 0-255
+		s, _ := d.(string)
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		return b, nil
+	case "array":
+		items, _ := d.([]interface{})
+		native := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if native[i], err = defaultNative(t.Items, item); err != nil {
+				return nil, err
+			}
+		}
+		return native, nil
+	case "map":
+		values, _ := d.(map[string]interface{})
+		native := make(map[string]interface{}, len(values))
+		for k, value := range values {
+			var err error
+			if native[k], err = defaultNative(t.Values, value); err != nil {
+				return nil, err
+			}
+		}
+		return native, nil
+	case "record":
+		values, _ := d.(map[string]interface{})
+		native := make(map[string]interface{}, len(t.Fields))
+		for _, f := range t.Fields {
+			value, ok := values[f.Name]
+			if !ok {
+				if !f.HasDefault {
+					return nil, fmt.Errorf("default of record %q has no field %q", t.Name, f.Name)
+				}
+				value = f.Default
+			}
+			var err error
+			if native[f.Name], err = defaultNative(f.Type, value); err != nil {
+				return nil, err
+			}
+		}
+		return native, nil
+	case "union":
+		if len(t.Branches) == 0 {
+			return nil, fmt.Errorf("empty union")
+		}
+		b := t.Branches[0]
+		if b.Type == "null" {
+			return nil, nil
+		}
+		native, err := defaultNative(b, d)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{b.unionName(): native}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q", t.Type)
+	}
+}
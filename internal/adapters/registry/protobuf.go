@@ -0,0 +1,181 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/riferrei/srclient"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// compileProtobuf compiles the .proto text of the schema along with the
+// schemas it imports, which are fetched from the registry by reference.
+func (r *Registry) compileProtobuf(s *srclient.Schema) (protoreflect.FileDescriptor, error) {
+	name := fmt.Sprintf("schema-%d.proto", s.ID())
+	files := map[string]string{name: s.Schema()}
+	if err := r.protobufReferences(s.References(), files); err != nil {
+		return nil, err
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(files),
+		}),
+	}
+	compiled, err := compiler.Compile(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return compiled[0], nil
+}
+
+func (r *Registry) protobufReferences(refs []srclient.Reference, files map[string]string) error {
+	for _, ref := range refs {
+		if _, ok := files[ref.Name]; ok {
+			continue
+		}
+
+		start := time.Now()
+		s, err := r.client.GetSchemaByVersion(ref.Subject, ref.Version)
+		observeFetch(start, err)
+		if err != nil {
+			return requestFailed(fmt.Errorf("get reference %q from subject %q version %d error: %w", ref.Name, ref.Subject, ref.Version, err))
+		}
+
+		files[ref.Name] = s.Schema()
+		if err := r.protobufReferences(s.References(), files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeProtobuf encodes the protobuf JSON value as the message of the
+// schema with the record name, prefixed by the message indexes.
+func (schema *registeredSchema) encodeProtobuf(value []byte, record string) ([]byte, error) {
+	md, indexes := schema.protobufMessage(record)
+	if md == nil {
+		return nil, fmt.Errorf("message %q not found in protobuf schema %d", record, schema.ID())
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(value, msg); err != nil {
+		return nil, fmt.Errorf("json to protobuf error: %w", err)
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal protobuf error: %w", err)
+	}
+
+	return append(messageIndexes(indexes), payload...), nil
+}
+
+// decodeProtobuf decodes the message the message indexes refer to into
+// canonical protobuf JSON.
+func (schema *registeredSchema) decodeProtobuf(value []byte) ([]byte, error) {
+	indexes, n, err := readMessageIndexes(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var md protoreflect.MessageDescriptor
+	messages := schema.File.Messages()
+	for _, i := range indexes {
+		if i < 0 || i >= messages.Len() {
+			return nil, fmt.Errorf("message index %v not found in protobuf schema %d", indexes, schema.ID())
+		}
+		md = messages.Get(i)
+		messages = md.Messages()
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(value[n:], msg); err != nil {
+		return nil, fmt.Errorf("unmarshal protobuf error: %w", err)
+	}
+	text, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf to json error: %w", err)
+	}
+
+	// protojson output is deliberately unstable in its whitespace
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, text); err != nil {
+		return nil, fmt.Errorf("compact json error: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// protobufMessage returns the message with the full name, or the first
+// message of the schema if the name is empty, along with its indexes.
+func (schema *registeredSchema) protobufMessage(name string) (protoreflect.MessageDescriptor, []int) {
+	var find func(messages protoreflect.MessageDescriptors, indexes []int) (protoreflect.MessageDescriptor, []int)
+	find = func(messages protoreflect.MessageDescriptors, indexes []int) (protoreflect.MessageDescriptor, []int) {
+		for i := 0; i < messages.Len(); i++ {
+			md := messages.Get(i)
+			path := append(indexes[:len(indexes):len(indexes)], i)
+			if name == "" || string(md.FullName()) == name {
+				return md, path
+			}
+			if found, path := find(md.Messages(), path); found != nil {
+				return found, path
+			}
+		}
+
+		return nil, nil
+	}
+
+	return find(schema.File.Messages(), nil)
+}
+
+// messageIndexes encodes the path of a message in its schema as zigzag
+// varints, with the first message of the schema written as a single 0.
+func messageIndexes(indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return []byte{0}
+	}
+
+	b := binary.AppendVarint(nil, int64(len(indexes)))
+	for _, i := range indexes {
+		b = binary.AppendVarint(b, int64(i))
+	}
+
+	return b
+}
+
+// readMessageIndexes returns the message indexes at the start of the value
+// and their length in bytes.
+func readMessageIndexes(value []byte) ([]int, int, error) {
+	errInvalid := errors.New("invalid protobuf message indexes")
+
+	count, n := binary.Varint(value)
+	if n <= 0 || count < 0 || count > int64(len(value)) {
+		return nil, 0, errInvalid
+	}
+	if count == 0 {
+		return []int{0}, n, nil
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, m := binary.Varint(value[n:])
+		if m <= 0 {
+			return nil, 0, errInvalid
+		}
+		indexes[i] = int(index)
+		n += m
+	}
+
+	return indexes, n, nil
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"kafka-sidecar/internal/metrics"
+
+	"github.com/riferrei/srclient"
+	"github.com/rs/zerolog/log"
+)
+
+func (r *Registry) autoRegister(topic, subject string, c TopicConfig, definition string) (*registeredSchema, error) {
+	if !c.AutoRegister {
+		return nil, fmt.Errorf("schema registration is not enabled for topic %q", topic)
+	}
+
+	schema, err := r.registerSchema(subject, definition)
+	if err != nil {
+		return nil, fmt.Errorf("register schema error: %w", err)
+	}
+
+	return schema, nil
+}
+
+// registerSchema returns the Avro schema with the definition from the
+// subject, registering it first if the subject does not have it yet. The
+// result is cached for as long as the sidecar runs.
+func (r *Registry) registerSchema(subject, definition string) (*registeredSchema, error) {
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, []byte(definition)); err != nil {
+		return nil, fmt.Errorf("invalid schema definition error: %w", err)
+	}
+	key := subject + "\x00" + buf.String()
+
+	r.mu.RLock()
+	schema := r.registered[key]
+	r.mu.RUnlock()
+
+	if schema != nil {
+		metrics.SchemaCacheRequests.WithLabelValues("hit").Inc()
+		return schema, nil
+	}
+	metrics.SchemaCacheRequests.WithLabelValues("miss").Inc()
+
+	v, err, _ := r.group.Do("register:"+key, func() (interface{}, error) {
+		s, err := r.lookupOrCreateSchema(subject, buf.String())
+		if err != nil {
+			return nil, err
+		}
+		schema, err := r.newRegisteredSchema(s)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mu.Lock()
+		r.registered[key] = schema
+		r.byID[uint32(schema.ID())] = schema
+		r.mu.Unlock()
+
+		return schema, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*registeredSchema), nil
+}
+
+// lookupOrCreateSchema registers the definition under the subject, unless it
+// has it already, after checking it is compatible with the latest schema of
+// the subject.
+func (r *Registry) lookupOrCreateSchema(subject, definition string) (*srclient.Schema, error) {
+	s, err := r.lookupCompatibleSchema(subject, definition)
+	if err != nil || s != nil {
+		return s, err
+	}
+
+	s, err = r.client.CreateSchema(subject, definition, srclient.Avro)
+	if err != nil {
+		return nil, requestFailed(fmt.Errorf("create schema in subject %q error: %w", subject, err))
+	}
+	log.Info().Str("subject", subject).Int("id", s.ID()).Msg("schema registered")
+
+	return s, nil
+}
+
+// lookupCompatibleSchema returns the schema with the definition from the
+// subject, or nil if the subject does not have it yet and it is compatible
+// with the latest schema of the subject.
+func (r *Registry) lookupCompatibleSchema(subject, definition string) (*srclient.Schema, error) {
+	s, err := r.client.LookupSchema(subject, definition, srclient.Avro)
+	if err == nil {
+		return s, nil
+	}
+	if !notFound(err) {
+		return nil, requestFailed(fmt.Errorf("lookup schema in subject %q error: %w", subject, err))
+	}
+
+	// a subject without versions accepts any schema
+	compatible, err := r.client.IsSchemaCompatible(subject, definition, "latest", srclient.Avro)
+	if err != nil && !notFound(err) {
+		return nil, requestFailed(fmt.Errorf("check compatibility with subject %q error: %w", subject, err))
+	}
+	if err == nil && !compatible {
+		return nil, fmt.Errorf("schema is not compatible with the latest schema of subject %q", subject)
+	}
+
+	return nil, nil
+}
+
+// previewSchema returns the Avro schema with the definition like
+// autoRegister does, but never registers it: a definition the subject does
+// not have yet is compiled locally, with schema ID 0.
+func (r *Registry) previewSchema(topic, subject string, c TopicConfig, definition string) (*registeredSchema, error) {
+	if !c.AutoRegister {
+		return nil, fmt.Errorf("schema registration is not enabled for topic %q", topic)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, []byte(definition)); err != nil {
+		return nil, fmt.Errorf("invalid schema definition error: %w", err)
+	}
+
+	r.mu.RLock()
+	schema := r.registered[subject+"\x00"+buf.String()]
+	r.mu.RUnlock()
+	if schema != nil {
+		return schema, nil
+	}
+
+	s, err := r.lookupCompatibleSchema(subject, buf.String())
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		if s, err = srclient.NewSchema(0, buf.String(), srclient.Avro, 0, nil, nil, nil); err != nil {
+			return nil, fmt.Errorf("create local schema error: %w", err)
+		}
+	}
+
+	return r.newRegisteredSchema(s)
+}
+
+func notFound(err error) bool {
+	switch errorCode(err) {
+	case subjectNotFoundCode, versionNotFoundCode, schemaNotFoundCode:
+		return true
+	default:
+		return false
+	}
+}
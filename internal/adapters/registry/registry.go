@@ -1,192 +1,565 @@
 package registry
 
 import (
+	"context"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"sort"
+	"kafka-sidecar/internal/metrics"
+	"kafka-sidecar/internal/models"
 	"sync"
 	"time"
 
 	"github.com/riferrei/srclient"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
 )
 
-type schemaBuf struct {
-	Schema   *srclient.Schema
-	Deadline time.Time
+var (
+	// ErrTombstone is returned when decoding a null value.
+	ErrTombstone = errors.New("value is a tombstone")
+	// ErrNotConfluentFormat is returned when decoding a value that does not
+	// start with the magic byte and a schema ID.
+	ErrNotConfluentFormat = errors.New("value is not in the confluent wire format")
+	// ErrUnknownSchemaID is returned when decoding a value whose schema ID is
+	// not known to the schema registry.
+	ErrUnknownSchemaID = errors.New("unknown schema id")
+)
+
+// Schema registry error codes of missing subjects, versions and schemas.
+const (
+	subjectNotFoundCode = 40401
+	versionNotFoundCode = 40402
+	schemaNotFoundCode  = 40403
+)
+
+// latestSchema is the latest schema of a subject and the time it is due to
+// be refreshed.
+type latestSchema struct {
+	Schema     *registeredSchema
+	RefreshAt  time.Time
+	Refreshing bool
 }
 
+// Registry caches schemas fetched by ID forever, as they are immutable, and
+// the latest schema of a subject for the refresh interval. A stale latest
+// schema is still served while it is refreshed in the background, and for as
+// long as the schema registry is unavailable. Concurrent fetches of the same
+// schema are deduplicated.
 type Registry struct {
 	client                    *srclient.SchemaRegistryClient
 	avroSchemaRefreshInterval int
 
-	mu      sync.Mutex
-	schemas map[string]map[uint32]*schemaBuf
+	group singleflight.Group
+
+	mu     sync.RWMutex
+	byID   map[uint32]*registeredSchema
+	latest map[string]*latestSchema
+	topics map[string]TopicConfig
+	// registered holds the schemas registered on produce by subject and
+	// definition.
+	registered map[string]*registeredSchema
+	// versions holds the schemas fetched by subject and version.
+	versions map[string]*registeredSchema
+	// outdatedPins holds the topics whose pinned schema is not the latest.
+	outdatedPins map[string]bool
+	// readers holds the reader schemas of topics defined by ReaderSchema.
+	readers map[string]*avroReader
 }
 
-func New(url string, avroSchemaRefreshInterval int) *Registry {
+// New creates a registry for the schema registry at url, authenticating
+// with the options.
+func New(url string, avroSchemaRefreshInterval int, opts ...Option) *Registry {
 	r := &Registry{
-		client:                    srclient.NewSchemaRegistryClient(url),
+		client:                    newClient(url, opts...),
 		avroSchemaRefreshInterval: avroSchemaRefreshInterval,
-		schemas:                   map[string]map[uint32]*schemaBuf{},
+		byID:                      map[uint32]*registeredSchema{},
+		latest:                    map[string]*latestSchema{},
+		topics:                    map[string]TopicConfig{},
+		registered:                map[string]*registeredSchema{},
+		versions:                  map[string]*registeredSchema{},
+		outdatedPins:              map[string]bool{},
+		readers:                   map[string]*avroReader{},
 	}
 
-	r.client.CodecJsonEnabled(true)
-	r.client.CodecCreationEnabled(true)
+	// codecs are created by newRegisteredSchema for every schema type
+	r.client.CodecCreationEnabled(false)
+	r.client.CachingEnabled(false)
 
 	return r
 }
 
-func (r *Registry) Encode(topic string, value []byte) ([]byte, error) {
-	schema, err := r.getSchema(topic+"-value", nil)
+// Encode encodes the JSON value in the value format of the topic. Avro values
+// are encoded with the latest schema of the subject that the subject name
+// strategy of the topic selects, or with the schema of the hint or the topic
+// if the topic auto-registers schemas.
+func (r *Registry) Encode(topic string, value []byte, hint models.SchemaHint) ([]byte, error) {
+	c := r.topicConfig(topic)
+	if c.ValueFormat != FormatAvro {
+		return encodePlain(c.ValueFormat, value)
+	}
+
+	subject, err := c.valueSubject(topic, hint.Record)
 	if err != nil {
-		return nil, fmt.Errorf("get schema error: %w", err)
+		return nil, err
+	}
+
+	schema, err := r.valueSchema(topic, subject, c, hint, true)
+	if err != nil {
+		return nil, err
 	}
 
-	var s schemaStruct
-	if err := json.Unmarshal([]byte(schema.Codec().Schema()), &s); err != nil {
-		return nil, fmt.Errorf("unmarshal schema error: %w", err)
+	return r.encodeSchema(schema, value, codecOptions{
+		Record:       hint.Record,
+		PlainJSON:    c.PlainJSON,
+		StrictFields: c.StrictFields,
+	})
+}
+
+// Validate runs the JSON value through Encode without producing it, and
+// without registering the schema defined by the hint or the topic. It
+// returns the value as it would be consumed, with unknown fields dropped and
+// defaults filled, or the errors of the fields that prevent encoding it. The
+// fields dropped from Avro values are returned as warnings. The error is only
+// set if the schema registry request for the schema failed.
+func (r *Registry) Validate(topic string, value []byte, hint models.SchemaHint) (models.Validation, error) {
+	c := r.topicConfig(topic)
+	if c.ValueFormat != FormatAvro {
+		encoded, err := encodePlain(c.ValueFormat, value)
+		if err != nil {
+			return models.Validation{Errors: fieldErrors(err)}, nil
+		}
+		normalized, err := decodePlain(c.ValueFormat, encoded)
+		return models.Validation{Value: normalized}, err
 	}
 
-	value, err = r.deleteUnnecessaryFields(s, value)
+	subject, err := c.valueSubject(topic, hint.Record)
 	if err != nil {
-		return nil, fmt.Errorf("delete unnecessary fields error: %w", err)
+		return models.Validation{Errors: fieldErrors(err)}, nil
 	}
 
-	native, _, err := schema.Codec().NativeFromTextual(value)
+	schema, err := r.valueSchema(topic, subject, c, hint, false)
+	if errors.Is(err, models.ErrSchemaRegistryUnavailable) {
+		return models.Validation{}, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf("text to native error: %w", err)
+		return models.Validation{Errors: fieldErrors(err)}, nil
+	}
+
+	encoded, err := r.encodeSchema(schema, value, codecOptions{
+		Record:       hint.Record,
+		PlainJSON:    c.PlainJSON,
+		StrictFields: c.StrictFields,
+		ReportFields: true,
+	})
+	var fe *fieldsError
+	if err != nil && schema.AvroType != nil && !errors.As(err, &fe) {
+		// goavro reports mismatched types without the path of the field
+		if errs := typeErrors(schema.AvroType, value, c.PlainJSON); len(errs) > 0 {
+			return models.Validation{Errors: errs}, nil
+		}
 	}
-	valueBytes, err := schema.Codec().BinaryFromNative(nil, native)
 	if err != nil {
-		return nil, fmt.Errorf("native to binary error: %w", err)
+		return models.Validation{Errors: fieldErrors(err)}, nil
 	}
 
-	schemaIDBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(schemaIDBytes, uint32(schema.ID()))
+	normalized, err := r.decodeSchema(schema, encoded[5:], codecOptions{PlainJSON: c.PlainJSON})
+	if err != nil {
+		return models.Validation{}, fmt.Errorf("decode encoded value error: %w", err)
+	}
 
-	recordValue := make([]byte, 0, 1+len(schemaIDBytes)+len(valueBytes))
-	recordValue = append(recordValue, byte(0))
-	recordValue = append(recordValue, schemaIDBytes...)
-	recordValue = append(recordValue, valueBytes...)
+	validation := models.Validation{Value: normalized}
+	if schema.AvroType != nil {
+		validation.Warnings = unknownFieldWarnings(schema.AvroType, value)
+	}
 
-	return recordValue, nil
+	return validation, nil
 }
 
+// valueSchema selects the schema a value is encoded with: a schema pinned or
+// defined by the hint, else a schema pinned or defined by the topic, else the
+// latest schema of the subject. Defined schemas are only registered with
+// register, and compiled locally otherwise.
+func (r *Registry) valueSchema(topic, subject string, c TopicConfig, hint models.SchemaHint, register bool) (*registeredSchema, error) {
+	defined := r.autoRegister
+	if !register {
+		defined = r.previewSchema
+	}
+
+	switch {
+	case hint.ID > 0 || hint.Version > 0:
+		return r.pinnedSchema(topic, subject, hint.ID, hint.Version)
+	case len(hint.Schema) > 0:
+		return defined(topic, subject, c, hint.Schema)
+	case c.SchemaID > 0 || c.SchemaVersion > 0:
+		return r.pinnedSchema(topic, subject, c.SchemaID, c.SchemaVersion)
+	case len(c.Schema) > 0:
+		return defined(topic, subject, c, c.Schema)
+	}
+
+	schema, err := r.getLatestSchema(subject)
+	if err != nil {
+		return nil, fmt.Errorf("get schema error: %w", err)
+	}
+
+	return schema, nil
+}
+
+// Decode decodes the value in the value format of the topic. Avro values are
+// decoded with the schema their embedded ID refers to, whatever subject the
+// schema is registered under, and projected into the reader schema of the
+// topic if it has one.
 func (r *Registry) Decode(topic string, value []byte) ([]byte, error) {
-	schemaID := binary.BigEndian.Uint32(value[1:5])
-	schema, err := r.getSchema(topic+"-value", &schemaID)
+	c := r.topicConfig(topic)
+	if value == nil {
+		return nil, ErrTombstone
+	}
+	if c.ValueFormat != FormatAvro {
+		return decodePlain(c.ValueFormat, value)
+	}
+
+	reader, err := r.readerSchema(topic, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.decodeSubject(value, codecOptions{
+		Validate:  c.ValidateOnConsume,
+		Reader:    reader,
+		PlainJSON: c.PlainJSON,
+	})
+}
+
+// codecOptions are the settings of a topic that apply to a value in the
+// Confluent wire format.
+type codecOptions struct {
+	// Record selects the message of a Protobuf schema, which is otherwise
+	// its first message.
+	Record string
+	// Validate validates decoded JSON Schema values.
+	Validate bool
+	// Reader is the schema decoded Avro values are projected into.
+	Reader *avroReader
+	// PlainJSON renders Avro values as plain JSON.
+	PlainJSON bool
+	// StrictFields fails encoding Avro values with fields unknown to the
+	// schema instead of dropping them, or with missing required fields.
+	StrictFields bool
+	// ReportFields fails encoding Avro values with missing required fields
+	// like StrictFields, but still drops unknown fields.
+	ReportFields bool
+}
+
+// encodeSubject encodes the JSON value with the latest schema of the
+// subject into the Confluent wire format.
+func (r *Registry) encodeSubject(subject string, value []byte, opts codecOptions) ([]byte, error) {
+	schema, err := r.getLatestSchema(subject)
 	if err != nil {
 		return nil, fmt.Errorf("get schema error: %w", err)
 	}
 
-	native, _, err := schema.Codec().NativeFromBinary(value[5:])
+	return r.encodeSchema(schema, value, opts)
+}
+
+func (r *Registry) encodeSchema(schema *registeredSchema, value []byte, opts codecOptions) ([]byte, error) {
+	var payload []byte
+	var err error
+	switch schema.Type {
+	case srclient.Json:
+		payload, err = schema.encodeJSON(value)
+	case srclient.Protobuf:
+		payload, err = schema.encodeProtobuf(value, opts.Record)
+	default:
+		payload, err = r.encodeAvro(schema, value, opts)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("binary to native error: %w", err)
+		return nil, err
+	}
+
+	return wireFormat(schema.ID(), payload), nil
+}
+
+// decodeSubject decodes a value in the Confluent wire format into JSON with
+// the schema the value refers to.
+func (r *Registry) decodeSubject(value []byte, opts codecOptions) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, ErrTombstone
+	}
+	if len(value) < 5 || value[0] != 0 {
+		return nil, ErrNotConfluentFormat
 	}
-	text, err := schema.Codec().TextualFromNative(nil, native)
+
+	schemaID := binary.BigEndian.Uint32(value[1:5])
+	schema, err := r.getSchemaByID(schemaID)
 	if err != nil {
-		return nil, fmt.Errorf("native to text error: %w", err)
+		return nil, fmt.Errorf("get schema error: %w", err)
 	}
 
-	return text, nil
+	if opts.Reader != nil && schema.Type != srclient.Avro {
+		return nil, fmt.Errorf("reader schema requires an avro writer schema, got %s", schema.Type)
+	}
+
+	return r.decodeSchema(schema, value[5:], opts)
 }
 
-func (r *Registry) getSchema(topic string, id *uint32) (*srclient.Schema, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// decodeSchema decodes a value without the wire format header into JSON with
+// the schema.
+func (r *Registry) decodeSchema(schema *registeredSchema, value []byte, opts codecOptions) ([]byte, error) {
+	switch schema.Type {
+	case srclient.Json:
+		return schema.decodeJSON(value, opts.Validate)
+	case srclient.Protobuf:
+		return schema.decodeProtobuf(value)
+	default:
+		return r.decodeAvro(schema, value, opts)
+	}
+}
 
-	var err error
-	var schema *srclient.Schema
-
-	if m := r.schemas[topic]; m != nil {
-		buf := &schemaBuf{}
-		if id != nil {
-			buf = m[*id]
-		} else if len(m) > 0 {
-			ids := make([]int, 0, len(m))
-			for u := range m {
-				ids = append(ids, int(u))
-			}
-			sort.Ints(ids)
-			buf = m[uint32(ids[len(ids)-1])]
+func (r *Registry) encodeAvro(schema *registeredSchema, value []byte, opts codecOptions) ([]byte, error) {
+	value, err := completeFields(schema.AvroType, value, opts)
+	if err != nil {
+		return nil, fmt.Errorf("complete fields error: %w", err)
+	}
 
+	if opts.PlainJSON {
+		native, err := nativeFromPlain(schema.AvroType, value)
+		if err != nil {
+			return nil, fmt.Errorf("plain json to native error: %w", err)
 		}
-		if buf.Deadline.After(time.Now()) {
-			schema = buf.Schema
+		valueBytes, err := schema.Codec.BinaryFromNative(nil, native)
+		if err != nil {
+			return nil, fmt.Errorf("native to binary error: %w", err)
 		}
+		return valueBytes, nil
 	}
 
+	native, _, err := schema.Codec.NativeFromTextual(value)
+	if err != nil {
+		return nil, fmt.Errorf("text to native error: %w", err)
+	}
+	valueBytes, err := schema.Codec.BinaryFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("native to binary error: %w", err)
+	}
+
+	return valueBytes, nil
+}
+
+func (r *Registry) decodeAvro(schema *registeredSchema, value []byte, opts codecOptions) ([]byte, error) {
+	native, _, err := schema.Decoder.NativeFromBinary(value)
+	if err != nil {
+		return nil, fmt.Errorf("binary to native error: %w", err)
+	}
+
+	codec, t := schema.Decoder, schema.AvroType
+	if opts.Reader != nil {
+		if native, err = project(t, opts.Reader.Type, native); err != nil {
+			return nil, fmt.Errorf("project into reader schema error: %w", err)
+		}
+		codec, t = opts.Reader.Codec, opts.Reader.Type
+	}
+
+	if opts.PlainJSON {
+		text, err := plainFromNative(t, native)
+		if err != nil {
+			return nil, fmt.Errorf("native to plain json error: %w", err)
+		}
+		return text, nil
+	}
+
+	text, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("native to text error: %w", err)
+	}
+
+	return text, nil
+}
+
+func (r *Registry) getSchemaByID(id uint32) (*registeredSchema, error) {
+	r.mu.RLock()
+	schema := r.byID[id]
+	r.mu.RUnlock()
+
 	if schema != nil {
+		metrics.SchemaCacheRequests.WithLabelValues("hit").Inc()
 		return schema, nil
 	}
+	metrics.SchemaCacheRequests.WithLabelValues("miss").Inc()
 
-	if id != nil {
-		schema, err = r.client.GetSchema(int(*id))
+	v, err, _ := r.group.Do(fmt.Sprintf("id:%d", id), func() (interface{}, error) {
+		start := time.Now()
+		s, err := r.client.GetSchema(int(id))
+		observeFetch(start, err)
+		if errorCode(err) == schemaNotFoundCode {
+			return nil, fmt.Errorf("%w %d", ErrUnknownSchemaID, id)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("get schema by id %d error: %w", id, err)
+			return nil, requestFailed(fmt.Errorf("get schema by id %d error: %w", id, err))
 		}
-	} else {
-		schema, err = r.client.GetLatestSchema(topic)
+		schema, err := r.newRegisteredSchema(s)
 		if err != nil {
-			return nil, fmt.Errorf("get latest schema from topic %q error: %w", topic, err)
+			return nil, err
 		}
-		uintId := uint32(schema.ID())
-		id = &uintId
+
+		r.mu.Lock()
+		r.byID[id] = schema
+		r.mu.Unlock()
+
+		return schema, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if r.schemas[topic] == nil {
-		r.schemas[topic] = map[uint32]*schemaBuf{}
+	return v.(*registeredSchema), nil
+}
+
+func (r *Registry) getLatestSchema(subject string) (*registeredSchema, error) {
+	r.mu.RLock()
+	cached := r.latest[subject]
+	r.mu.RUnlock()
+
+	if cached == nil {
+		metrics.SchemaCacheRequests.WithLabelValues("miss").Inc()
+		return r.fetchLatestSchema(subject)
 	}
-	r.schemas[topic][*id] = &schemaBuf{
-		schema,
-		time.Now(),
+
+	metrics.SchemaCacheRequests.WithLabelValues("hit").Inc()
+
+	r.mu.Lock()
+	refresh := !cached.Refreshing && time.Now().After(cached.RefreshAt)
+	if refresh {
+		cached.Refreshing = true
 	}
+	r.mu.Unlock()
 
-	return schema, nil
-}
+	if refresh {
+		go func() {
+			if _, err := r.fetchLatestSchema(subject); err != nil {
+				log.Warn().Err(err).Str("subject", subject).Msg("refresh schema error, serving stale schema")
 
-type schemaStruct struct {
-	Fields []struct {
-		Name string          `json:"name"`
-		Type json.RawMessage `json:"type"`
-	} `json:"fields"`
-}
-
-func (r *Registry) deleteUnnecessaryFields(s schemaStruct, b []byte) ([]byte, error) {
-	var m map[string]json.RawMessage
-	if err := json.Unmarshal(b, &m); err != nil {
-		return nil, fmt.Errorf("unmarshal message error: %w", err)
-	}
-
-	needDelete := make([]string, 0, len(m))
-	for fieldName := range m {
-		ok := false
-		for i := 0; i < len(s.Fields); i++ {
-			if s.Fields[i].Name == fieldName {
-				var fs schemaStruct
-				if err := json.Unmarshal(s.Fields[i].Type, &fs); err == nil {
-					if b, err = r.deleteUnnecessaryFields(fs, m[fieldName]); err == nil {
-						m[fieldName] = b
-					}
-				}
-				ok = true
-				break
+				// retry after another refresh interval
+				r.mu.Lock()
+				cached.Refreshing = false
+				cached.RefreshAt = time.Now().Add(r.refreshInterval())
+				r.mu.Unlock()
 			}
+		}()
+	}
+
+	return cached.Schema, nil
+}
+
+func (r *Registry) refreshInterval() time.Duration {
+	return time.Duration(r.avroSchemaRefreshInterval) * time.Second
+}
+
+func (r *Registry) fetchLatestSchema(subject string) (*registeredSchema, error) {
+	v, err, _ := r.group.Do("latest:"+subject, func() (interface{}, error) {
+		start := time.Now()
+		s, err := r.client.GetLatestSchema(subject)
+		observeFetch(start, err)
+		if err != nil {
+			return nil, requestFailed(fmt.Errorf("get latest schema from subject %q error: %w", subject, err))
+		}
+		schema, err := r.newRegisteredSchema(s)
+		if err != nil {
+			return nil, err
 		}
-		if !ok {
-			needDelete = append(needDelete, fieldName)
+
+		r.mu.Lock()
+		r.latest[subject] = &latestSchema{
+			Schema:    schema,
+			RefreshAt: time.Now().Add(r.refreshInterval()),
+		}
+		r.byID[uint32(schema.ID())] = schema
+		r.mu.Unlock()
+
+		return schema, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*registeredSchema), nil
+}
+
+// requestError is the error of a schema registry request that failed in
+// transport or with a server error. Its message is that of the wrapped error.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+func (e *requestError) Is(target error) bool {
+	return target == models.ErrSchemaRegistryUnavailable
+}
+
+// requestFailed wraps the error of a failed schema registry request in a
+// requestError, unless the schema registry rejected the request as a client
+// error, like a missing subject or an invalid schema.
+func requestFailed(err error) error {
+	if status := responseStatus(err); status > 0 && status < 500 {
+		return err
+	}
+
+	return &requestError{err}
+}
+
+// responseStatus returns the HTTP status of the schema registry response
+// that err describes, or 0 if there was no response. Schema registry error
+// codes start with the HTTP status, like 40401.
+func responseStatus(err error) int {
+	if code := errorCode(err); code > 0 {
+		for code >= 1000 {
+			code /= 10
 		}
+		return code
 	}
 
-	for _, fieldName := range needDelete {
-		delete(m, fieldName)
+	// srclient describes responses without an error body by their status
+	inner := err
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		inner = e
 	}
-	var err error
-	b, err = json.Marshal(m)
+	var status int
+	if _, scanErr := fmt.Sscanf(inner.Error(), "%d ", &status); scanErr == nil && status >= 100 && status < 600 {
+		return status
+	}
+
+	return 0
+}
+
+// errorCode returns the schema registry error code of err, or 0.
+func errorCode(err error) int {
+	var srErr srclient.Error
+	if errors.As(err, &srErr) {
+		return srErr.Code
+	}
+
+	return 0
+}
+
+func observeFetch(start time.Time, err error) {
+	result := "ok"
 	if err != nil {
-		return nil, fmt.Errorf("marshal message error: %w", err)
+		result = "error"
+	}
+	metrics.SchemaFetchDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+// Ping succeeds if the schema registry responds.
+func (r *Registry) Ping(_ context.Context) error {
+	if _, err := r.client.GetGlobalCompatibilityLevel(); err != nil {
+		return fmt.Errorf("get global compatibility level error: %w", err)
 	}
 
-	return b, nil
+	return nil
 }
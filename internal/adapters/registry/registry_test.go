@@ -1,13 +1,25 @@
 package registry_test
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"kafka-sidecar/internal/adapters/registry"
+	"kafka-sidecar/internal/metrics"
+	"kafka-sidecar/internal/models"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -40,7 +52,7 @@ func TestRegistry(t *testing.T) {
 
 	for i, msg := range testTable {
 		t.Run(fmt.Sprintf("test #%d", i), func(t *testing.T) {
-			e, err := tr.Encode("test", msg.MessageBefore)
+			e, err := tr.Encode("test", msg.MessageBefore, models.SchemaHint{})
 			require.NoError(t, err)
 
 			b, err := tr.Decode("test", e)
@@ -56,3 +68,703 @@ func TestRegistry(t *testing.T) {
 		})
 	}
 }
+
+const testSchemaResponse = `{"subject":"test-value","version":1,"id":1,"schema":"{\"type\":\"record\",\"name\":\"test\",\"fields\":[{\"name\":\"id\",\"type\":\"string\"}]}"}`
+
+func TestRegistryCache(t *testing.T) {
+	var requests atomic.Int32
+	var failing atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, testSchemaResponse)
+	}))
+	defer ts.Close()
+
+	t.Run("fresh", func(t *testing.T) {
+		requests.Store(0)
+		tr := registry.New(ts.URL, 10)
+
+		_, err := tr.Encode("test", []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.NoError(t, err)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e, err := tr.Encode("test", []byte(`{"id": "test"}`), models.SchemaHint{})
+				require.NoError(t, err)
+				_, err = tr.Decode("test", e)
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("stale while error", func(t *testing.T) {
+		requests.Store(0)
+		tr := registry.New(ts.URL, 0)
+
+		_, err := tr.Encode("test", []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.NoError(t, err)
+
+		failing.Store(true)
+		defer failing.Store(false)
+		for i := 0; i < 5; i++ {
+			_, err = tr.Encode("test", []byte(`{"id": "test"}`), models.SchemaHint{})
+			require.NoError(t, err)
+		}
+
+		require.Eventually(t, func() bool { return requests.Load() > 1 }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestRegistryKey(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"subject":"avro-key","version":1,"id":2,"schema":"{\"type\":\"record\",\"name\":\"key\",\"fields\":[{\"name\":\"id\",\"type\":\"long\"}]}"}`)
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("bytes", registry.TopicConfig{KeyFormat: registry.FormatBytes})
+	tr.SetTopicConfig("avro", registry.TopicConfig{KeyFormat: registry.FormatAvro})
+
+	var keyTable = []struct {
+		Topic string
+		Key   []byte
+		Raw   []byte
+	}{
+		{"string", []byte(`"abc"`), []byte("abc")},
+		{"bytes", []byte(`"AAEC"`), []byte{0, 1, 2}},
+		{"avro", []byte(`{"id": 42}`), []byte{0, 0, 0, 0, 2, 84}},
+		{"avro", []byte(`null`), nil},
+	}
+
+	for i, k := range keyTable {
+		t.Run(fmt.Sprintf("key #%d", i), func(t *testing.T) {
+			raw, err := tr.EncodeKey(k.Topic, k.Key)
+			require.NoError(t, err)
+			require.Equal(t, k.Raw, raw)
+
+			key, err := tr.DecodeKey(k.Topic, raw)
+			require.NoError(t, err)
+			require.JSONEq(t, string(k.Key), string(key))
+		})
+	}
+}
+
+func TestRegistrySubjectNameStrategy(t *testing.T) {
+	var subjects []string
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		subjects = append(subjects, r.URL.Path)
+		mu.Unlock()
+		fmt.Fprintln(w, testSchemaResponse)
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("record", registry.TopicConfig{SubjectNameStrategy: registry.RecordNameStrategy})
+	tr.SetTopicConfig("topic_record", registry.TopicConfig{SubjectNameStrategy: registry.TopicRecordNameStrategy})
+
+	value := []byte(`{"id": "test"}`)
+	hint := models.SchemaHint{Record: "com.acme.Order"}
+
+	_, err := tr.Encode("topic", value, hint)
+	require.NoError(t, err)
+	_, err = tr.Encode("record", value, hint)
+	require.NoError(t, err)
+	_, err = tr.Encode("topic_record", value, hint)
+	require.NoError(t, err)
+	_, err = tr.Encode("record", value, models.SchemaHint{})
+	require.Error(t, err)
+
+	require.Equal(t, []string{
+		"/subjects/topic-value/versions/latest",
+		"/subjects/com.acme.Order/versions/latest",
+		"/subjects/topic_record-com.acme.Order/versions/latest",
+	}, subjects)
+}
+
+func TestRegistryJSONSchema(t *testing.T) {
+	jsonSchema, _ := json.Marshal(`{"type": "object", "properties": {"id": {"type": "string"}}, "required": ["id"]}`)
+	jsonSchemaResponse := fmt.Sprintf(`{"subject": "json-value", "version": 1, "id": 2, "schemaType": "JSON", "schema": %s}`, jsonSchema)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subjects/json-value/versions/latest", "/schemas/ids/2":
+			fmt.Fprintln(w, jsonSchemaResponse)
+		default:
+			fmt.Fprintln(w, testSchemaResponse)
+		}
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("json", registry.TopicConfig{ValidateOnConsume: true})
+
+	t.Run("round trip", func(t *testing.T) {
+		encoded, err := tr.Encode("json", []byte(`{"id": "test", "extra": 1}`), models.SchemaHint{})
+		require.NoError(t, err)
+		require.Equal(t, append([]byte{0, 0, 0, 0, 2}, `{"id":"test","extra":1}`...), encoded)
+
+		decoded, err := tr.Decode("json", encoded)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"id": "test", "extra": 1}`, string(decoded))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := tr.Encode("json", []byte(`{"extra": 1}`), models.SchemaHint{})
+		require.Error(t, err)
+
+		_, err = tr.Decode("json", append([]byte{0, 0, 0, 0, 2}, `{"extra":1}`...))
+		require.Error(t, err)
+	})
+
+	t.Run("mixed", func(t *testing.T) {
+		encoded, err := tr.Encode("topic", []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0, 0, 0, 0, 1}, encoded[:5])
+
+		decoded, err := tr.Decode("topic", encoded)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"id": "test"}`, string(decoded))
+	})
+}
+
+func TestRegistryProtobuf(t *testing.T) {
+	common, _ := json.Marshal(`syntax = "proto3"; package acme; message Money { string currency = 1; int64 units = 2; }`)
+	order, _ := json.Marshal(`syntax = "proto3"; package acme; import "common.proto";
+message Order { string id = 1; Money total = 2; message Line { string sku = 1; } }`)
+	orderResponse := fmt.Sprintf(`{"subject": "proto-value", "version": 1, "id": 3, "schemaType": "PROTOBUF", "schema": %s, "references": [{"name": "common.proto", "subject": "common", "version": 1}]}`, order)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subjects/proto-value/versions/latest", "/schemas/ids/3":
+			fmt.Fprintln(w, orderResponse)
+		case "/subjects/common/versions/1":
+			fmt.Fprintf(w, `{"subject": "common", "version": 1, "id": 4, "schemaType": "PROTOBUF", "schema": %s}`+"\n", common)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+
+	encoded, err := tr.Encode("proto", []byte(`{"id": "test", "total": {"currency": "EUR", "units": "10"}, "unknown": 1}`), models.SchemaHint{})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0, 0, 0, 3, 0}, encoded[:6])
+
+	decoded, err := tr.Decode("proto", encoded)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id": "test", "total": {"currency": "EUR", "units": "10"}}`, string(decoded))
+
+	encoded, err = tr.Encode("proto", []byte(`{"sku": "a-1"}`), models.SchemaHint{Record: "acme.Order.Line"})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 0, 0, 0, 3, 4, 0, 0}, encoded[:8])
+
+	decoded, err = tr.Decode("proto", encoded)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"sku": "a-1"}`, string(decoded))
+
+	_, err = tr.Encode("proto", []byte(`{}`), models.SchemaHint{Record: "acme.Missing"})
+	require.Error(t, err)
+}
+
+func TestRegistryValueFormat(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected schema registry request %s", r.URL.Path)
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("json", registry.TopicConfig{ValueFormat: registry.FormatJSON})
+	tr.SetTopicConfig("string", registry.TopicConfig{ValueFormat: registry.FormatString})
+	tr.SetTopicConfig("bytes", registry.TopicConfig{ValueFormat: registry.FormatBytes})
+
+	for _, tc := range []struct {
+		Topic   string
+		Value   string
+		Encoded []byte
+	}{
+		{"json", `{"id": "test"}`, []byte(`{"id":"test"}`)},
+		{"string", `"plain text"`, []byte("plain text")},
+		{"bytes", `"AAH/"`, []byte{0, 1, 255}},
+	} {
+		encoded, err := tr.Encode(tc.Topic, []byte(tc.Value), models.SchemaHint{})
+		require.NoError(t, err)
+		require.Equal(t, tc.Encoded, encoded)
+
+		decoded, err := tr.Decode(tc.Topic, encoded)
+		require.NoError(t, err)
+		require.JSONEq(t, tc.Value, string(decoded))
+	}
+
+	_, err := tr.Encode("json", []byte(`{"id":`), models.SchemaHint{})
+	require.Error(t, err)
+	_, err = tr.Decode("json", []byte("not json"))
+	require.Error(t, err)
+	_, err = tr.Encode("string", []byte(`{"id": "test"}`), models.SchemaHint{})
+	require.Error(t, err)
+}
+
+func TestRegistryAutoRegister(t *testing.T) {
+	definition := `{"type": "record", "name": "test", "fields": [{"name": "id", "type": "string"}]}`
+
+	var mu sync.Mutex
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /subjects/new-value", "POST /subjects/old-value":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"error_code": 40401, "message": "Subject not found"}`)
+		case "POST /compatibility/subjects/new-value/versions/latest":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"error_code": 40401, "message": "Subject not found"}`)
+		case "POST /compatibility/subjects/old-value/versions/latest":
+			fmt.Fprintln(w, `{"is_compatible": false}`)
+		case "POST /subjects/new-value/versions":
+			fmt.Fprintln(w, `{"id": 1}`)
+		default:
+			fmt.Fprintln(w, testSchemaResponse)
+		}
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("new", registry.TopicConfig{AutoRegister: true, Schema: definition})
+	tr.SetTopicConfig("old", registry.TopicConfig{AutoRegister: true})
+
+	validation, err := tr.Validate("new", []byte(`{"id": "test", "extra": 1}`), models.SchemaHint{})
+	require.NoError(t, err)
+	require.Empty(t, validation.Errors)
+	require.JSONEq(t, `{"id": "test"}`, string(validation.Value))
+	require.Equal(t, []string{
+		"POST /subjects/new-value",
+		"POST /compatibility/subjects/new-value/versions/latest",
+	}, requests)
+	requests = nil
+
+	for i := 0; i < 2; i++ {
+		encoded, err := tr.Encode("new", []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0, 0, 0, 0, 1}, encoded[:5])
+	}
+	require.Equal(t, []string{
+		"POST /subjects/new-value",
+		"POST /compatibility/subjects/new-value/versions/latest",
+		"POST /subjects/new-value/versions",
+		"GET /schemas/ids/1",
+	}, requests)
+
+	_, err = tr.Encode("old", []byte(`{"id": "test"}`), models.SchemaHint{Schema: definition})
+	require.ErrorContains(t, err, "not compatible")
+	require.NotErrorIs(t, err, models.ErrSchemaRegistryUnavailable)
+
+	_, err = tr.Encode("topic", []byte(`{"id": "test"}`), models.SchemaHint{Schema: definition})
+	require.ErrorContains(t, err, "not enabled")
+}
+
+func TestRegistryPin(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/subjects/topic-value/versions/1", "/schemas/ids/7":
+			fmt.Fprintln(w, strings.Replace(testSchemaResponse, `"id":1`, `"id":7`, 1))
+		default:
+			fmt.Fprintln(w, testSchemaResponse)
+		}
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("topic", registry.TopicConfig{SchemaVersion: 1})
+
+	value := []byte(`{"id": "test"}`)
+	for _, tc := range []struct {
+		Hint     models.SchemaHint
+		SchemaID byte
+	}{
+		{models.SchemaHint{}, 7},
+		{models.SchemaHint{ID: 1}, 1},
+		{models.SchemaHint{ID: 7, Version: 2}, 7},
+	} {
+		encoded, err := tr.Encode("topic", value, tc.Hint)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0, 0, 0, 0, tc.SchemaID}, encoded[:5])
+	}
+
+	require.Equal(t, []string{
+		"/subjects/topic-value/versions/1",
+		"/subjects/topic-value/versions/latest",
+	}, requests)
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.SchemaPinOutdated.WithLabelValues("topic")))
+}
+
+func TestRegistryReaderSchema(t *testing.T) {
+	writer, _ := json.Marshal(`{"type": "record", "name": "Order", "namespace": "acme", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "count", "type": "int"},
+		{"name": "note", "type": ["null", "string"]},
+		{"name": "removed", "type": "string"}
+	]}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"subject": "orders-value", "version": 2, "id": 2, "schema": %s}`+"\n", writer)
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("orders", registry.TopicConfig{ReaderSchema: `{"type": "record", "name": "Order", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "count", "type": "double"},
+		{"name": "note", "type": ["null", "string"], "default": null},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "DONE"]}, "default": "NEW"},
+		{"name": "tags", "type": {"type": "array", "items": "string"}, "default": []}
+	]}`})
+	tr.SetTopicConfig("orders-strict", registry.TopicConfig{ReaderSchema: `{"type": "record", "name": "Order", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "missing", "type": "string"}
+	]}`})
+
+	encoded, err := tr.Encode("orders-raw", []byte(`{"id": "test", "count": 3, "note": "fragile", "removed": "x"}`), models.SchemaHint{})
+	require.NoError(t, err)
+
+	decoded, err := tr.Decode("orders", encoded)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id": "test", "count": 3, "note": "fragile", "status": "NEW", "tags": []}`, string(decoded))
+
+	_, err = tr.Decode("orders-strict", encoded)
+	require.ErrorContains(t, err, "missing")
+}
+
+func TestRegistryPlainJSON(t *testing.T) {
+	schema, _ := json.Marshal(`{"type": "record", "name": "Event", "fields": [
+		{"name": "note", "type": ["null", "string"]},
+		{"name": "amount", "type": ["null", "int", "long"]},
+		{"name": "at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "day", "type": {"type": "int", "logicalType": "date"}},
+		{"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+		{"name": "id", "type": {"type": "string", "logicalType": "uuid"}},
+		{"name": "source", "type": "string", "default": "api"}
+	]}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"subject": "events-value", "version": 1, "id": 1, "schema": %s}`+"\n", schema)
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("events", registry.TopicConfig{PlainJSON: true})
+
+	encoded, err := tr.Encode("events", []byte(`{
+		"note": "x",
+		"amount": {"long": 5},
+		"at": "2023-11-14T22:13:20.5Z",
+		"day": "2022-01-08",
+		"price": "12.30",
+		"id": "0b5d3a6e-3f7e-4d52-9e8b-3f4f7a0c1d2e"
+	}`), models.SchemaHint{})
+	require.NoError(t, err)
+
+	decoded, err := tr.Decode("events", encoded)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"note": "x",
+		"amount": 5,
+		"at": "2023-11-14T22:13:20.5Z",
+		"day": "2022-01-08",
+		"price": "12.30",
+		"id": "0b5d3a6e-3f7e-4d52-9e8b-3f4f7a0c1d2e",
+		"source": "api"
+	}`, string(decoded))
+
+	_, err = tr.Encode("events", []byte(`{"note": null, "amount": 5, "at": 0, "day": 0, "price": "1", "id": ""}`), models.SchemaHint{})
+	require.ErrorContains(t, err, "ambiguous")
+
+	_, err = tr.Encode("events", []byte(`{"note": 1, "amount": null, "at": 0, "day": 0, "price": "1", "id": ""}`), models.SchemaHint{})
+	require.ErrorContains(t, err, "fits no branch")
+}
+
+func TestRegistryPruneFields(t *testing.T) {
+	schema, _ := json.Marshal(`{"type": "record", "name": "Order", "namespace": "acme", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [
+			{"name": "sku", "type": "string"}
+		]}}},
+		{"name": "byWarehouse", "type": {"type": "map", "values": "Item"}},
+		{"name": "gift", "type": ["null", "Item"]}
+	]}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"subject": "orders-value", "version": 1, "id": 1, "schema": %s}`+"\n", schema)
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("strict", registry.TopicConfig{StrictFields: true})
+
+	value := []byte(`{
+		"id": "o1",
+		"extra": 1,
+		"items": [{"sku": "a", "qty": 2}],
+		"byWarehouse": {"w1": {"sku": "b", "bin": "x"}},
+		"gift": {"sku": "c", "note": "y"}
+	}`)
+
+	encoded, err := tr.Encode("orders", value, models.SchemaHint{})
+	require.NoError(t, err)
+
+	decoded, err := tr.Decode("orders", encoded)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"id": "o1",
+		"items": [{"sku": "a"}],
+		"byWarehouse": {"w1": {"sku": "b"}},
+		"gift": {"sku": "c"}
+	}`, string(decoded))
+
+	_, err = tr.Encode("strict", value, models.SchemaHint{})
+	require.ErrorContains(t, err, `$.byWarehouse["w1"].bin, $.extra, $.gift.note, $.items[0].qty`)
+}
+
+func TestRegistryDefaults(t *testing.T) {
+	schema, _ := json.Marshal(`{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "active", "type": "boolean", "default": true},
+		{"name": "nickname", "type": ["null", "string"], "default": null},
+		{"name": "address", "type": {"type": "record", "name": "Address", "fields": [
+			{"name": "street", "type": "string"},
+			{"name": "country", "type": "string", "default": "NL"}
+		]}, "default": {"street": "unknown"}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}, "default": []}
+	]}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"subject": "users-value", "version": 1, "id": 1, "schema": %s}`+"\n", schema)
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+	tr.SetTopicConfig("strict", registry.TopicConfig{StrictFields: true})
+
+	for value, expected := range map[string]string{
+		`{"id": "u1"}`: `{"id": "u1", "active": true, "nickname": null, "address": {"street": "unknown", "country": "NL"}, "tags": []}`,
+		`{"id": "u2", "address": {"street": "Main"}, "nickname": "u"}`: `{"id": "u2", "active": true, "nickname": "u", "address": {"street": "Main", "country": "NL"}, "tags": []}`,
+	} {
+		encoded, err := tr.Encode("users", []byte(value), models.SchemaHint{})
+		require.NoError(t, err)
+
+		decoded, err := tr.Decode("users", encoded)
+		require.NoError(t, err)
+		require.JSONEq(t, expected, string(decoded))
+	}
+
+	_, err := tr.Encode("users", []byte(`{"address": {}}`), models.SchemaHint{})
+	require.Error(t, err)
+
+	_, err = tr.Encode("strict", []byte(`{"address": {}, "extra": 1}`), models.SchemaHint{})
+	require.ErrorContains(t, err, "fields unknown to the schema: $.extra; missing required fields: $.address.street, $.id")
+}
+
+func TestRegistryValidate(t *testing.T) {
+	avroSchema, _ := json.Marshal(`{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "string"},
+		{"name": "active", "type": "boolean", "default": true},
+		{"name": "address", "type": {"type": "record", "name": "Address", "fields": [
+			{"name": "street", "type": "string"}
+		]}}
+	]}`)
+	cartSchema, _ := json.Marshal(`{"type": "record", "name": "Cart", "fields": [
+		{"name": "items", "type": {"type": "array", "items": {"type": "record", "name": "Item", "fields": [
+			{"name": "qty", "type": "int"}
+		]}}}
+	]}`)
+	jsonSchema, _ := json.Marshal(`{"type": "object", "properties": {
+		"items": {"type": "array", "items": {"type": "object", "required": ["sku"]}}
+	}}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subjects/orders-value/versions/latest":
+			fmt.Fprintf(w, `{"subject": "orders-value", "version": 1, "id": 2, "schemaType": "JSON", "schema": %s}`+"\n", jsonSchema)
+		case "/subjects/carts-value/versions/latest":
+			fmt.Fprintf(w, `{"subject": "carts-value", "version": 1, "id": 3, "schema": %s}`+"\n", cartSchema)
+		default:
+			fmt.Fprintf(w, `{"subject": "users-value", "version": 1, "id": 1, "schema": %s}`+"\n", avroSchema)
+		}
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+
+	validation, err := tr.Validate("users", []byte(`{"id": "u1", "address": {"street": "Main", "number": 1}}`), models.SchemaHint{})
+	require.NoError(t, err)
+	require.Empty(t, validation.Errors)
+	require.JSONEq(t, `{"id": "u1", "active": true, "address": {"street": "Main"}}`, string(validation.Value))
+	require.Equal(t, []models.FieldError{
+		{Path: "$.address.number", Message: "field is unknown to the schema and dropped"},
+	}, validation.Warnings)
+
+	validation, err = tr.Validate("users", []byte(`{"address": {}}`), models.SchemaHint{})
+	require.NoError(t, err)
+	require.Equal(t, []models.FieldError{
+		{Path: "$.address.street", Message: "required field is missing"},
+		{Path: "$.id", Message: "required field is missing"},
+	}, validation.Errors)
+
+	validation, err = tr.Validate("carts", []byte(`{"items": [{"qty": 1}, {"qty": "many"}]}`), models.SchemaHint{})
+	require.NoError(t, err)
+	require.Equal(t, []models.FieldError{
+		{Path: "$.items[1].qty", Message: "expected int, got many"},
+	}, validation.Errors)
+
+	validation, err = tr.Validate("orders", []byte(`{"items": [{"sku": "a"}, {}]}`), models.SchemaHint{})
+	require.NoError(t, err)
+	require.Len(t, validation.Errors, 1)
+	require.Equal(t, "$.items[1]", validation.Errors[0].Path)
+}
+
+func TestRegistryAuth(t *testing.T) {
+	var auth string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		fmt.Fprintln(w, testSchemaResponse)
+	}))
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	tlsConfig := &tls.Config{RootCAs: pool}
+
+	t.Run("basic", func(t *testing.T) {
+		tr := registry.New(ts.URL, 10, registry.WithTLSConfig(tlsConfig), registry.WithBasicAuth("user", "secret"))
+		_, err := tr.Encode("topic", []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.NoError(t, err)
+		require.Equal(t, "Basic dXNlcjpzZWNyZXQ=", auth)
+	})
+
+	t.Run("bearer token file", func(t *testing.T) {
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("first\n"), 0o600))
+
+		tr := registry.New(ts.URL, 10, registry.WithTLSConfig(tlsConfig), registry.WithBearerTokenFile(tokenFile))
+		_, err := tr.Encode("topic", []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.NoError(t, err)
+		require.Equal(t, "Bearer first", auth)
+
+		require.NoError(t, os.WriteFile(tokenFile, []byte("second\n"), 0o600))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(tokenFile, later, later))
+
+		require.NoError(t, tr.Ping(context.Background()))
+		require.Equal(t, "Bearer second", auth)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		tr := registry.New(ts.URL, 10)
+		_, err := tr.Encode("topic", []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.Error(t, err)
+	})
+}
+
+func TestRegistryUnavailable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subjects/missing-value/versions/latest":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"error_code": 40401, "message": "Subject not found"}`)
+		case "/subjects/topic-value/versions/9":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"error_code": 40402, "message": "Version not found"}`)
+		case "/subjects/gateway-value/versions/latest":
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintln(w, `<html>Bad Gateway</html>`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, `{"error_code": 50001, "message": "Error in the backend data store"}`)
+		}
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+
+	for _, topic := range []string{"topic", "gateway"} {
+		_, err := tr.Encode(topic, []byte(`{"id": "test"}`), models.SchemaHint{})
+		require.ErrorIs(t, err, models.ErrSchemaRegistryUnavailable)
+	}
+
+	_, err := tr.Validate("topic", []byte(`{"id": "test"}`), models.SchemaHint{})
+	require.ErrorIs(t, err, models.ErrSchemaRegistryUnavailable)
+
+	_, err = tr.Encode("missing", []byte(`{"id": "test"}`), models.SchemaHint{})
+	require.ErrorContains(t, err, "Subject not found")
+	require.NotErrorIs(t, err, models.ErrSchemaRegistryUnavailable)
+
+	_, err = tr.Encode("topic", []byte(`{"id": "test"}`), models.SchemaHint{Version: 9})
+	require.ErrorContains(t, err, "Version not found")
+	require.NotErrorIs(t, err, models.ErrSchemaRegistryUnavailable)
+
+	ts.Close()
+	_, err = registry.New(ts.URL, 10).Encode("topic", []byte(`{"id": "test"}`), models.SchemaHint{})
+	require.ErrorIs(t, err, models.ErrSchemaRegistryUnavailable)
+}
+
+func FuzzDecode(f *testing.F) {
+	jsonSchema, _ := json.Marshal(`{"type": "object"}`)
+	protoSchema, _ := json.Marshal(`syntax = "proto3"; message Order { string id = 1; message Line { string sku = 1; } }`)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			fmt.Fprintln(w, testSchemaResponse)
+		case "/schemas/ids/2":
+			fmt.Fprintf(w, `{"id": 2, "schemaType": "JSON", "schema": %s}`+"\n", jsonSchema)
+		case "/schemas/ids/3":
+			fmt.Fprintf(w, `{"id": 3, "schemaType": "PROTOBUF", "schema": %s}`+"\n", protoSchema)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"error_code": 40403, "message": "Schema not found"}`)
+		}
+	}))
+	defer ts.Close()
+
+	tr := registry.New(ts.URL, 10)
+
+	f.Add([]byte{})
+	f.Add([]byte{0})
+	f.Add([]byte("plain"))
+	f.Add([]byte{0, 0, 0, 0, 1, 8, 't', 'e', 's', 't'})
+	f.Add([]byte{0, 0, 0, 0, 1, 200})
+	f.Add(append([]byte{0, 0, 0, 0, 2}, `{"id":"test"}`...))
+	f.Add([]byte{0, 0, 0, 0, 3, 0, 10, 4, 't', 'e', 's', 't'})
+	f.Add([]byte{0, 0, 0, 0, 3, 4, 0, 0, 10, 1, 'a'})
+	f.Add([]byte{0, 0, 0, 0, 3, 255, 255, 255})
+	f.Add([]byte{0, 0, 0, 0, 9})
+
+	f.Fuzz(func(t *testing.T, value []byte) {
+		decoded, err := tr.Decode("topic", value)
+		switch {
+		case len(value) == 0:
+			require.ErrorIs(t, err, registry.ErrTombstone)
+		case len(value) < 5 || value[0] != 0:
+			require.ErrorIs(t, err, registry.ErrNotConfluentFormat)
+		case value[1] != 0 || value[2] != 0 || value[3] != 0 || value[4] > 3 || value[4] == 0:
+			require.ErrorIs(t, err, registry.ErrUnknownSchemaID)
+		case err == nil:
+			require.True(t, json.Valid(decoded))
+		}
+	})
+}
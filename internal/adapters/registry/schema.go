@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/riferrei/srclient"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// registeredSchema is a schema from the registry prepared for encoding and
+// decoding according to its type.
+type registeredSchema struct {
+	*srclient.Schema
+	Type srclient.SchemaType
+
+	// Codec, Decoder and AvroType are set for Avro schemas. Decoder is a
+	// codec of its own for decoding, as goavro reorders the union branches
+	// of a codec when it parses textual values, which breaks the branch names
+	// of unions it decodes from binary afterwards.
+	Codec    *goavro.Codec
+	Decoder  *goavro.Codec
+	AvroType *avroType
+	// JSONSchema is set for JSON schemas.
+	JSONSchema *jsonschema.Schema
+	// File is set for Protobuf schemas.
+	File protoreflect.FileDescriptor
+}
+
+func (r *Registry) newRegisteredSchema(s *srclient.Schema) (*registeredSchema, error) {
+	schema := &registeredSchema{Schema: s, Type: srclient.Avro}
+	if s.SchemaType() != nil && len(*s.SchemaType()) > 0 {
+		schema.Type = *s.SchemaType()
+	}
+
+	var err error
+	switch schema.Type {
+	case srclient.Avro:
+		schema.Codec, err = goavro.NewCodecForStandardJSONFull(s.Schema())
+		if err != nil {
+			return nil, fmt.Errorf("create avro codec for schema %d error: %w", s.ID(), err)
+		}
+		schema.Decoder, err = goavro.NewCodecForStandardJSONFull(s.Schema())
+		if err != nil {
+			return nil, fmt.Errorf("create avro codec for schema %d error: %w", s.ID(), err)
+		}
+		schema.AvroType, err = parseAvroSchema(s.Schema())
+		if err != nil {
+			return nil, fmt.Errorf("parse avro schema %d error: %w", s.ID(), err)
+		}
+	case srclient.Json:
+		schema.JSONSchema, err = jsonschema.CompileString(fmt.Sprintf("schema-%d.json", s.ID()), s.Schema())
+		if err != nil {
+			return nil, fmt.Errorf("compile json schema %d error: %w", s.ID(), err)
+		}
+	case srclient.Protobuf:
+		schema.File, err = r.compileProtobuf(s)
+		if err != nil {
+			return nil, fmt.Errorf("compile protobuf schema %d error: %w", s.ID(), err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %q of schema %d", schema.Type, s.ID())
+	}
+
+	return schema, nil
+}
+
+// encodeJSON validates the JSON value against the schema and compacts it.
+func (schema *registeredSchema) encodeJSON(value []byte) ([]byte, error) {
+	if err := schema.validateJSON(value); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, value); err != nil {
+		return nil, fmt.Errorf("compact json error: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (schema *registeredSchema) decodeJSON(value []byte, validate bool) ([]byte, error) {
+	if validate {
+		if err := schema.validateJSON(value); err != nil {
+			return nil, err
+		}
+	} else if !json.Valid(value) {
+		return nil, fmt.Errorf("value of json schema %d is not valid json", schema.ID())
+	}
+
+	return value, nil
+}
+
+func (schema *registeredSchema) validateJSON(value []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("unmarshal json error: %w", err)
+	}
+	if err := schema.JSONSchema.Validate(v); err != nil {
+		return fmt.Errorf("validate json error: %w", err)
+	}
+
+	return nil
+}
+
+// wireFormat prepends the magic byte and the schema ID to the payload.
+func wireFormat(schemaID int, payload []byte) []byte {
+	schemaIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(schemaIDBytes, uint32(schemaID))
+
+	recordValue := make([]byte, 0, 1+len(schemaIDBytes)+len(payload))
+	recordValue = append(recordValue, byte(0))
+	recordValue = append(recordValue, schemaIDBytes...)
+	recordValue = append(recordValue, payload...)
+
+	return recordValue
+}
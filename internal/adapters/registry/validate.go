@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kafka-sidecar/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// fieldErrors describes the error encoding a value as errors of its fields.
+func fieldErrors(err error) []models.FieldError {
+	var fErr *fieldsError
+	if errors.As(err, &fErr) {
+		var errs []models.FieldError
+		for _, path := range fErr.Unknown {
+			errs = append(errs, models.FieldError{Path: path, Message: "field is unknown to the schema"})
+		}
+		for _, path := range fErr.Missing {
+			errs = append(errs, models.FieldError{Path: path, Message: "required field is missing"})
+		}
+		return errs
+	}
+
+	var vErr *jsonschema.ValidationError
+	if errors.As(err, &vErr) {
+		return validationErrors(vErr)
+	}
+
+	return []models.FieldError{{Path: "$", Message: err.Error()}}
+}
+
+// validationErrors flattens the causes of a JSON Schema validation error
+// into the errors of the fields they are located at.
+func validationErrors(vErr *jsonschema.ValidationError) []models.FieldError {
+	if len(vErr.Causes) == 0 {
+		return []models.FieldError{{Path: jsonPath(vErr.InstanceLocation), Message: vErr.Message}}
+	}
+
+	var errs []models.FieldError
+	for _, cause := range vErr.Causes {
+		errs = append(errs, validationErrors(cause)...)
+	}
+
+	return errs
+}
+
+// jsonPath converts a JSON pointer like /items/0/sku into a JSON path like
+// $.items[0].sku.
+func jsonPath(pointer string) string {
+	path := "$"
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		if _, err := strconv.Atoi(token); err == nil {
+			path += "[" + token + "]"
+			continue
+		}
+		path += "." + token
+	}
+
+	return path
+}
+
+// unknownFieldWarnings returns the paths of the fields of the JSON value
+// unknown to the Avro schema, which encoding drops.
+func unknownFieldWarnings(t *avroType, value []byte) []models.FieldError {
+	v, err := decodeJSONValue(value)
+	if err != nil {
+		return nil
+	}
+
+	w := &fieldWalk{}
+	w.value(t, v, "$")
+
+	var warnings []models.FieldError
+	for _, path := range w.unknown {
+		warnings = append(warnings, models.FieldError{Path: path, Message: "field is unknown to the schema and dropped"})
+	}
+
+	return warnings
+}
+
+// typeErrors returns the errors of the fields of the JSON value whose type
+// does not match the Avro schema. It explains values goavro fails to encode
+// without naming the field. Logical types are only checked for plain JSON,
+// whose string forms goavro does not accept.
+func typeErrors(t *avroType, value []byte, plain bool) []models.FieldError {
+	v, err := decodeJSONValue(value)
+	if err != nil {
+		return []models.FieldError{{Path: "$", Message: err.Error()}}
+	}
+
+	return valueTypeErrors(t, v, "$", plain)
+}
+
+func valueTypeErrors(t *avroType, v interface{}, path string, plain bool) []models.FieldError {
+	switch t.Type {
+	case "union":
+		if b, inner, _ := unionBranchOf(t, v); b != nil {
+			return valueTypeErrors(b, inner, path, plain)
+		}
+		names := make([]string, len(t.Branches))
+		for i, b := range t.Branches {
+			if len(valueTypeErrors(b, v, path, plain)) == 0 {
+				return nil
+			}
+			names[i] = b.unionName()
+		}
+		return []models.FieldError{{Path: path, Message: fmt.Sprintf("value %v fits no branch of union [%s]", v, strings.Join(names, ", "))}}
+	case "record":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return []models.FieldError{{Path: path, Message: fmt.Sprintf("expected record %q, got %v", t.Name, v)}}
+		}
+		var errs []models.FieldError
+		for _, f := range t.Fields {
+			if fv, ok := values[f.Name]; ok {
+				errs = append(errs, valueTypeErrors(f.Type, fv, path+"."+f.Name, plain)...)
+			}
+		}
+		return errs
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return []models.FieldError{{Path: path, Message: fmt.Sprintf("expected array, got %v", v)}}
+		}
+		var errs []models.FieldError
+		for i, item := range items {
+			errs = append(errs, valueTypeErrors(t.Items, item, fmt.Sprintf("%s[%d]", path, i), plain)...)
+		}
+		return errs
+	case "map":
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			return []models.FieldError{{Path: path, Message: fmt.Sprintf("expected object, got %v", v)}}
+		}
+		var errs []models.FieldError
+		for k, value := range values {
+			errs = append(errs, valueTypeErrors(t.Values, value, fmt.Sprintf("%s[%q]", path, k), plain)...)
+		}
+		return errs
+	}
+
+	if len(t.Logical) > 0 && !plain {
+		return nil
+	}
+	if _, err := nativeValue(t, v); err != nil {
+		return []models.FieldError{{Path: path, Message: err.Error()}}
+	}
+
+	return nil
+}
+
+func decodeJSONValue(value []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("unmarshal json error: %w", err)
+	}
+
+	return v, nil
+}
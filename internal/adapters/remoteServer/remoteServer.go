@@ -6,10 +6,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"kafka-sidecar/internal/metrics"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+// StatusError is returned by Send when the remote server responds with a
+// status code other than 200.
+type StatusError struct {
+	Code       int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid response code %d, %s", e.Code, e.Status)
+}
+
+func (e *StatusError) StatusCode() int {
+	return e.Code
+}
+
+func (e *StatusError) RetryAfterDelay() time.Duration {
+	return e.RetryAfter
+}
+
 type RemoteServer struct {
 	Url string
 }
@@ -22,15 +44,17 @@ func (rs *RemoteServer) Send(ctx context.Context, topic string, headers map[stri
 	data := struct {
 		Topic     string            `json:"topic"`
 		Headers   map[string]string `json:"headers"`
-		Key       string            `json:"key"`
+		Key       json.RawMessage   `json:"key"`
 		Value     json.RawMessage   `json:"value"`
+		Tombstone bool              `json:"tombstone,omitempty"`
 		Timestamp int64             `json:"timestamp"`
 		Offset    int64             `json:"offset"`
 	}{
 		Topic:     topic,
 		Headers:   headers,
-		Key:       string(key),
+		Key:       key,
 		Value:     value,
+		Tombstone: value == nil,
 		Timestamp: timestamp.UnixMilli(),
 		Offset:    offset,
 	}
@@ -52,10 +76,13 @@ func (rs *RemoteServer) Send(ctx context.Context, topic string, headers map[stri
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		metrics.RemoteRequestDuration.WithLabelValues(topic, "error").Observe(time.Since(start).Seconds())
 		return nil, fmt.Errorf("do request error: %w", err)
 	}
+	metrics.RemoteRequestDuration.WithLabelValues(topic, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
 	defer func() {
 		if resp.Body != nil {
 			_ = resp.Body.Close()
@@ -63,7 +90,11 @@ func (rs *RemoteServer) Send(ctx context.Context, topic string, headers map[stri
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("invalid response code %d, %s", resp.StatusCode, resp.Status)
+		return nil, &StatusError{
+			Code:       resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -73,3 +104,21 @@ func (rs *RemoteServer) Send(ctx context.Context, topic string, headers map[stri
 
 	return body, nil
 }
+
+// parseRetryAfter supports both forms of the Retry-After header: a number of
+// seconds and an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if len(v) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
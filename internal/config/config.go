@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -16,12 +17,46 @@ type conf struct {
 	KafkaConsumerGroupId      string
 	AllowedTopics             []string
 	SchemaRegistryUrl         string
+	SchemaRegistryUsername    string
+	SchemaRegistryPassword    string
+	SchemaRegistryTokenFile   string
+	SchemaRegistryCaFile      string
+	SchemaRegistryCertFile    string
+	SchemaRegistryKeyFile     string
 	HttpRoute                 string
 	HttpPort                  int
 	TerminateOnError          bool
 	CommitOnSuccess           bool
 	StartupDelay              int
 	AvroSchemaRefreshInterval int
+	DeadLetterTopics          map[string]string
+	RemoteRetryMaxAttempts    int
+	RemoteRetryInitialBackoff int
+	RemoteRetryMaxBackoff     int
+	RemoteRetryJitter         float64
+	RemoteRetryStatusCodes    []int
+	RetryDelays               []string
+	Workers                   int
+	Ordering                  string
+	ShutdownTimeout           int
+	AdminPort                 int
+	AppHealthUrl              string
+	AppHealthTimeout          int
+	WaitForApp                bool
+	AppHealthInterval         int
+	AppUnhealthyThreshold     int
+	KeyFormats                map[string]string
+	ValueFormats              map[string]string
+	SubjectNameStrategies     map[string]string
+	ValidateOnConsume         []string
+	AutoRegisterSchemas       []string
+	SchemaFiles               map[string]string
+	SchemaIds                 map[string]string
+	SchemaVersions            map[string]string
+	ReaderSchemaVersions      map[string]string
+	ReaderSchemaFiles         map[string]string
+	PlainJson                 []string
+	StrictFields              []string
 }
 
 var Config conf
@@ -33,12 +68,46 @@ func init() {
 	Config.KafkaConsumerGroupId = getEnv("KAFKA_CONSUMER_GROUP_ID", "")
 	Config.AllowedTopics = helpers.RemoveEmptyStrings(strings.Split(getEnv("ALLOWED_TOPICS", ""), ","))
 	Config.SchemaRegistryUrl = getEnv("SCHEMA_REGISTRY_URL", "http://localhost:8081")
+	Config.SchemaRegistryUsername = getEnv("SCHEMA_REGISTRY_USERNAME", "")
+	Config.SchemaRegistryPassword = getEnv("SCHEMA_REGISTRY_PASSWORD", "")
+	Config.SchemaRegistryTokenFile = getEnv("SCHEMA_REGISTRY_TOKEN_FILE", "")
+	Config.SchemaRegistryCaFile = getEnv("SCHEMA_REGISTRY_CA_FILE", "")
+	Config.SchemaRegistryCertFile = getEnv("SCHEMA_REGISTRY_CERT_FILE", "")
+	Config.SchemaRegistryKeyFile = getEnv("SCHEMA_REGISTRY_KEY_FILE", "")
 	Config.HttpRoute = getEnv("HTTP_ROUTE", "")
 	Config.HttpPort, _ = strconv.Atoi(getEnv("HTTP_PORT", ""))
 	Config.TerminateOnError, _ = strconv.ParseBool(getEnv("TERMINATE_ON_ERROR", "true"))
 	Config.CommitOnSuccess, _ = strconv.ParseBool(getEnv("COMMIT_ON_SUCCESS", "true"))
 	Config.StartupDelay, _ = strconv.Atoi(getEnv("STARTUP_DELAY", "0"))
 	Config.AvroSchemaRefreshInterval, _ = strconv.Atoi(getEnv("AVRO_SCHEMA_REFRESH_INTERVAL", "10"))
+	Config.DeadLetterTopics = helpers.ParseMap(getEnv("DEAD_LETTER_TOPICS", ""))
+	Config.RemoteRetryMaxAttempts, _ = strconv.Atoi(getEnv("REMOTE_RETRY_MAX_ATTEMPTS", "1"))
+	Config.RemoteRetryInitialBackoff, _ = strconv.Atoi(getEnv("REMOTE_RETRY_INITIAL_BACKOFF_MS", "100"))
+	Config.RemoteRetryMaxBackoff, _ = strconv.Atoi(getEnv("REMOTE_RETRY_MAX_BACKOFF_MS", "10000"))
+	Config.RemoteRetryJitter, _ = strconv.ParseFloat(getEnv("REMOTE_RETRY_JITTER", "0.2"), 64)
+	Config.RemoteRetryStatusCodes = helpers.ParseInts(strings.Split(getEnv("REMOTE_RETRY_STATUS_CODES", "429,502,503,504"), ","))
+	Config.RetryDelays = helpers.RemoveEmptyStrings(strings.Split(getEnv("RETRY_DELAYS", ""), ","))
+	Config.Workers, _ = strconv.Atoi(getEnv("WORKERS", "1"))
+	Config.Ordering = getEnv("ORDERING", "partition")
+	Config.ShutdownTimeout, _ = strconv.Atoi(getEnv("SHUTDOWN_TIMEOUT", "30"))
+	Config.AdminPort, _ = strconv.Atoi(getEnv("ADMIN_PORT", "8090"))
+	Config.AppHealthUrl = getEnv("APP_HEALTH_URL", "")
+	Config.AppHealthTimeout, _ = strconv.Atoi(getEnv("APP_HEALTH_TIMEOUT", "2"))
+	Config.WaitForApp, _ = strconv.ParseBool(getEnv("WAIT_FOR_APP", "false"))
+	Config.AppHealthInterval, _ = strconv.Atoi(getEnv("APP_HEALTH_INTERVAL", "1"))
+	Config.AppUnhealthyThreshold, _ = strconv.Atoi(getEnv("APP_UNHEALTHY_THRESHOLD", "30"))
+	Config.KeyFormats = helpers.ParseMap(getEnv("KEY_FORMATS", ""))
+	Config.ValueFormats = helpers.ParseMap(getEnv("VALUE_FORMATS", ""))
+	Config.SubjectNameStrategies = helpers.ParseMap(getEnv("SUBJECT_NAME_STRATEGIES", ""))
+	Config.ValidateOnConsume = helpers.RemoveEmptyStrings(strings.Split(getEnv("VALIDATE_ON_CONSUME", ""), ","))
+	Config.AutoRegisterSchemas = helpers.RemoveEmptyStrings(strings.Split(getEnv("AUTO_REGISTER_SCHEMAS", ""), ","))
+	Config.SchemaFiles = helpers.ParseMap(getEnv("SCHEMA_FILES", ""))
+	Config.SchemaIds = helpers.ParseMap(getEnv("SCHEMA_IDS", ""))
+	Config.SchemaVersions = helpers.ParseMap(getEnv("SCHEMA_VERSIONS", ""))
+	Config.ReaderSchemaVersions = helpers.ParseMap(getEnv("READER_SCHEMA_VERSIONS", ""))
+	Config.ReaderSchemaFiles = helpers.ParseMap(getEnv("READER_SCHEMA_FILES", ""))
+	Config.PlainJson = helpers.RemoveEmptyStrings(strings.Split(getEnv("PLAIN_JSON", ""), ","))
+	Config.StrictFields = helpers.RemoveEmptyStrings(strings.Split(getEnv("STRICT_FIELDS", ""), ","))
 
 	if len(Config.HttpRoute) == 0 && Config.HttpPort < 1 {
 		log.Fatal().Msg("myst specify HTTP_ROUTE or HTTP_PORT")
@@ -48,6 +117,73 @@ func init() {
 		log.Fatal().Msg("KAFKA_CONSUMER_GROUP_ID and KAFKA_TOPICS are required when HTTP_ROUTE is filled in")
 	}
 
+	for _, delay := range Config.RetryDelays {
+		if _, err := time.ParseDuration(delay); err != nil {
+			log.Fatal().Err(err).Msgf("invalid RETRY_DELAYS value %q", delay)
+		}
+	}
+
+	if Config.WaitForApp && (len(Config.AppHealthUrl) == 0 || Config.AppHealthInterval < 1) {
+		log.Fatal().Msg("APP_HEALTH_URL and a positive APP_HEALTH_INTERVAL are required when WAIT_FOR_APP is true")
+	}
+
+	if Config.Ordering != "partition" && Config.Ordering != "key" {
+		log.Fatal().Msgf("invalid ORDERING value %q, must be partition or key", Config.Ordering)
+	}
+
+	for topic, format := range Config.KeyFormats {
+		if !helpers.InArrayString([]string{"string", "bytes", "json", "avro"}, format) {
+			log.Fatal().Msgf("invalid KEY_FORMATS value %q for topic %q, must be string, bytes, json or avro", format, topic)
+		}
+	}
+
+	for topic, format := range Config.ValueFormats {
+		if !helpers.InArrayString([]string{"string", "bytes", "json", "avro"}, format) {
+			log.Fatal().Msgf("invalid VALUE_FORMATS value %q for topic %q, must be string, bytes, json or avro", format, topic)
+		}
+	}
+
+	for topic := range Config.SchemaFiles {
+		if !helpers.InArrayString(Config.AutoRegisterSchemas, topic) {
+			log.Fatal().Msgf("SCHEMA_FILES topic %q must be listed in AUTO_REGISTER_SCHEMAS", topic)
+		}
+	}
+
+	for topic, id := range Config.SchemaIds {
+		if n, err := strconv.Atoi(id); err != nil || n < 1 {
+			log.Fatal().Msgf("invalid SCHEMA_IDS value %q for topic %q, must be a positive integer", id, topic)
+		}
+	}
+
+	for topic, version := range Config.SchemaVersions {
+		if n, err := strconv.Atoi(version); err != nil || n < 1 {
+			log.Fatal().Msgf("invalid SCHEMA_VERSIONS value %q for topic %q, must be a positive integer", version, topic)
+		}
+	}
+
+	for topic, version := range Config.ReaderSchemaVersions {
+		if n, err := strconv.Atoi(version); err != nil || n < 1 {
+			log.Fatal().Msgf("invalid READER_SCHEMA_VERSIONS value %q for topic %q, must be a positive integer", version, topic)
+		}
+		if strategy, ok := Config.SubjectNameStrategies[topic]; ok && strategy != "topic" {
+			log.Fatal().Msgf("READER_SCHEMA_VERSIONS topic %q needs the topic subject name strategy, use READER_SCHEMA_FILES with the %s strategy", topic, strategy)
+		}
+	}
+
+	if len(Config.SchemaRegistryUsername) > 0 && len(Config.SchemaRegistryTokenFile) > 0 {
+		log.Fatal().Msg("SCHEMA_REGISTRY_USERNAME and SCHEMA_REGISTRY_TOKEN_FILE cannot be used together")
+	}
+
+	if (len(Config.SchemaRegistryCertFile) > 0) != (len(Config.SchemaRegistryKeyFile) > 0) {
+		log.Fatal().Msg("SCHEMA_REGISTRY_CERT_FILE and SCHEMA_REGISTRY_KEY_FILE must be set together")
+	}
+
+	for topic, strategy := range Config.SubjectNameStrategies {
+		if !helpers.InArrayString([]string{"topic", "record", "topic_record"}, strategy) {
+			log.Fatal().Msgf("invalid SUBJECT_NAME_STRATEGIES value %q for topic %q, must be topic, record or topic_record", strategy, topic)
+		}
+	}
+
 	log.Info().Strs("Brokers", Config.KafkaBrokers).
 		Strs("Topics", Config.KafkaTopics).
 		Str("GroupID", Config.KafkaConsumerGroupId).
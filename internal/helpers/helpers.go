@@ -1,6 +1,9 @@
 package helpers
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 func InArrayString(a []string, s string) bool {
 	for _, s2 := range a {
@@ -23,3 +26,32 @@ func RemoveEmptyStrings(s []string) []string {
 
 	return cleaned
 }
+
+// ParseInts converts s to integers, skipping empty and malformed entries.
+func ParseInts(s []string) []int {
+	ints := make([]int, 0, len(s))
+	for _, v := range RemoveEmptyStrings(s) {
+		if i, err := strconv.Atoi(v); err == nil {
+			ints = append(ints, i)
+		}
+	}
+
+	return ints
+}
+
+// ParseMap parses a comma-separated list of key:value pairs such as
+// "orders:orders.dlq,payments:payments.dlq". Pairs without a colon or with an
+// empty key or value are skipped.
+func ParseMap(s string) map[string]string {
+	m := map[string]string{}
+	for _, pair := range RemoveEmptyStrings(strings.Split(s, ",")) {
+		k, v, ok := strings.Cut(pair, ":")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || len(k) == 0 || len(v) == 0 {
+			continue
+		}
+		m[k] = v
+	}
+
+	return m
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "kafka_sidecar"
+
+var (
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Messages fetched from Kafka.",
+	}, []string{"topic"})
+
+	MessagesCommitted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_committed_total",
+		Help:      "Messages whose offsets were committed.",
+	}, []string{"topic"})
+
+	MessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages that failed processing, by pipeline stage.",
+	}, []string{"topic", "stage"})
+
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_lag",
+		Help:      "Messages between the last fetched offset and the high watermark of the partition.",
+	}, []string{"topic", "partition"})
+
+	PartitionStopped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "partition_stopped",
+		Help:      "1 if the partition stopped at a message that could not be processed nor handed to a retry or dead-letter topic, until restart.",
+	}, []string{"topic", "partition"})
+
+	RemoteRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "remote_request_duration_seconds",
+		Help:      "Duration of requests to HTTP_ROUTE by response status code, or \"error\" for transport errors.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic", "code"})
+
+	SchemaCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "schema_cache_requests_total",
+		Help:      "Schema lookups by cache result (hit or miss).",
+	}, []string{"result"})
+
+	SchemaFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "schema_fetch_duration_seconds",
+		Help:      "Duration of schema registry requests by result (ok or error).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	SchemaPinOutdated = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "schema_pin_outdated",
+		Help:      "1 if the schema pinned for the topic is no longer the latest schema of its subject.",
+	}, []string{"topic"})
+
+	ProduceDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "produce_duration_seconds",
+		Help:      "Duration of writes to Kafka.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic"})
+
+	ProduceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "produce_errors_total",
+		Help:      "Failed writes to Kafka.",
+	}, []string{"topic"})
+)
@@ -0,0 +1,39 @@
+package models
+
+import "errors"
+
+// ErrSchemaRegistryUnavailable is matched by the errors of failed schema
+// registry requests, as opposed to values that cannot be encoded or decoded.
+var ErrSchemaRegistryUnavailable = errors.New("schema registry unavailable")
+
+// SchemaHint carries the choices the application makes about the schema a
+// produced value is encoded with.
+type SchemaHint struct {
+	// Record is the full name of the record, e.g. com.acme.Order. It selects
+	// the subject of topics using a record name strategy.
+	Record string
+	// Schema is an Avro schema definition to encode the value with. It is
+	// registered under the subject of the topic if it is not yet.
+	Schema string
+	// ID or else Version pins the schema of the value, overriding the schema
+	// pinned for the topic.
+	ID      int
+	Version int
+}
+
+// FieldError is a problem with the field of a value at the JSON path, like
+// $.items[0].sku. The path is $ for problems with the value as a whole.
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Validation is the outcome of validating a value against the schema of a
+// topic. Value is the value as it would be consumed, set when Errors is
+// empty. Warnings are problems that do not prevent encoding the value, like
+// fields that are dropped.
+type Validation struct {
+	Value    []byte
+	Errors   []FieldError
+	Warnings []FieldError
+}
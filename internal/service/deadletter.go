@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	stageDecode    = "decode"
+	stageRemote    = "remote"
+	stageUnmarshal = "unmarshal"
+	stageProduce   = "produce"
+)
+
+const (
+	headerErrorStage      = "dead_letter_error_stage"
+	headerErrorMessage    = "dead_letter_error"
+	headerSourceTopic     = "dead_letter_source_topic"
+	headerSourcePartition = "dead_letter_source_partition"
+	headerSourceOffset    = "dead_letter_source_offset"
+	headerAttempts        = "dead_letter_attempts"
+)
+
+// processingError keeps the pipeline stage at which kafkaProcessing failed.
+type processingError struct {
+	Stage    string
+	Attempts int
+	Err      error
+}
+
+func (e *processingError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *processingError) Unwrap() error {
+	return e.Err
+}
+
+func errorStage(err error) string {
+	var pErr *processingError
+	if errors.As(err, &pErr) {
+		return pErr.Stage
+	}
+
+	return "unknown"
+}
+
+// handleFailure moves a failed message to its next retry topic or, once the
+// retry topics are exhausted, to its dead-letter topic. It returns false if
+// neither is configured.
+func (s *Service) handleFailure(ctx context.Context, m kafka.Message, cause error) (bool, error) {
+	o := s.origin(m)
+
+	var pErr *processingError
+	if errors.As(cause, &pErr) {
+		o.Attempts += pErr.Attempts
+	} else {
+		o.Attempts++
+	}
+
+	if tiers := s.RetryTopics[o.Topic]; o.Tier+1 < len(tiers) {
+		if err := s.retry(ctx, m, o, tiers[o.Tier+1]); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	return s.deadLetter(ctx, m, o, cause)
+}
+
+// deadLetter produces the failed message to the dead-letter topic configured
+// for its original topic. It returns false if there is no such topic.
+func (s *Service) deadLetter(ctx context.Context, m kafka.Message, o origin, cause error) (bool, error) {
+	topic, ok := s.DeadLetterTopics[o.Topic]
+	if !ok {
+		return false, nil
+	}
+
+	log.Debug().
+		Str("topic", m.Topic).
+		Str("dead_letter_topic", topic).
+		Int64("offset", m.Offset).
+		Msg("send message to dead-letter topic")
+
+	_, err := s.KafkaSender.Send(ctx, kafka.Message{
+		Topic: topic,
+		Key:   m.Key,
+		Value: m.Value,
+		Headers: replaceHeaders(m.Headers, map[string]string{
+			headerErrorStage:      errorStage(cause),
+			headerErrorMessage:    cause.Error(),
+			headerSourceTopic:     o.Topic,
+			headerSourcePartition: strconv.Itoa(o.Partition),
+			headerSourceOffset:    strconv.FormatInt(o.Offset, 10),
+			headerAttempts:        strconv.Itoa(o.Attempts),
+		}),
+	})
+	if err != nil {
+		return false, fmt.Errorf("send message to dead-letter topic %q error: %w", topic, err)
+	}
+
+	return true, nil
+}
+
+// replaceHeaders returns a copy of headers where the given keys are set to
+// the given values.
+func replaceHeaders(headers []kafka.Header, values map[string]string) []kafka.Header {
+	res := make([]kafka.Header, 0, len(headers)+len(values))
+	for _, h := range headers {
+		if _, ok := values[h.Key]; !ok {
+			res = append(res, h)
+		}
+	}
+	for k, v := range values {
+		res = append(res, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	return res
+}
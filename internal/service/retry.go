@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RetryPolicy controls how many times a request to RemoteServer is repeated
+// before the message is considered failed.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction (0..1) of the backoff that is randomized.
+	Jitter      float64
+	StatusCodes []int
+}
+
+// statusError is implemented by RemoteServer errors caused by an HTTP
+// response rather than by the transport.
+type statusError interface {
+	StatusCode() int
+	RetryAfterDelay() time.Duration
+}
+
+// retryable reports whether err is worth another attempt and the delay the
+// remote server asked for, if any.
+func (p RetryPolicy) retryable(err error) (bool, time.Duration) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+
+	var sErr statusError
+	if !errors.As(err, &sErr) {
+		return true, 0
+	}
+	for _, code := range p.StatusCodes {
+		if code == sErr.StatusCode() {
+			return true, sErr.RetryAfterDelay()
+		}
+	}
+
+	return false, 0
+}
+
+// backoff returns the delay before the given (1-based) retry.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 && d > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+
+	return d
+}
+
+// withRetry calls fn until it succeeds, returns a non-retryable error or the
+// attempts are exhausted. It returns the number of attempts made.
+func (p RetryPolicy) withRetry(ctx context.Context, fn func() error) (int, error) {
+	attempt := 0
+	for {
+		attempt++
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts {
+			return attempt, err
+		}
+
+		ok, delay := p.retryable(err)
+		if !ok {
+			return attempt, err
+		}
+		if delay == 0 {
+			delay = p.backoff(attempt)
+		}
+		// a remote server must not hold the partition longer than the
+		// configured backoff allows
+		if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+			delay = p.MaxBackoff
+		}
+
+		log.Warn().Err(err).
+			Int("attempt", attempt).
+			Dur("backoff", delay).
+			Msg("retrying request to remote server")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testStatusError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+func (e testStatusError) Error() string                  { return "status error" }
+func (e testStatusError) StatusCode() int                { return e.code }
+func (e testStatusError) RetryAfterDelay() time.Duration { return e.retryAfter }
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+		StatusCodes:    []int{503},
+	}
+
+	var testTable = []struct {
+		Name     string
+		Errors   []error
+		Attempts int
+		Success  bool
+	}{
+		{"success", []error{nil}, 1, true},
+		{"network error then success", []error{errors.New("connection refused"), nil}, 2, true},
+		{"retryable status exhausted", []error{testStatusError{code: 503}, testStatusError{code: 503}, testStatusError{code: 503}}, 3, false},
+		{"non-retryable status", []error{testStatusError{code: 400}, nil}, 1, false},
+		{"retry after", []error{testStatusError{code: 503, retryAfter: time.Millisecond}, nil}, 2, true},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.Name, func(t *testing.T) {
+			calls := 0
+			attempts, err := p.withRetry(context.Background(), func() error {
+				err := tc.Errors[calls]
+				calls++
+				return err
+			})
+			require.Equal(t, tc.Attempts, attempts)
+			require.Equal(t, tc.Attempts, calls)
+			require.Equal(t, tc.Success, err == nil)
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	require.Equal(t, 100*time.Millisecond, p.backoff(1))
+	require.Equal(t, 200*time.Millisecond, p.backoff(2))
+	require.Equal(t, 400*time.Millisecond, p.backoff(3))
+	require.Equal(t, time.Second, p.backoff(10))
+}
+
+func TestRetryPolicyRetryAfterCapped(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 2, MaxBackoff: 10 * time.Millisecond, StatusCodes: []int{429}}
+
+	calls := 0
+	start := time.Now()
+	attempts, err := p.withRetry(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			return testStatusError{code: 429, retryAfter: time.Hour}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+	require.Less(t, time.Since(start), time.Second)
+}
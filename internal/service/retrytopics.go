@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	headerRetryTopic     = "retry_original_topic"
+	headerRetryPartition = "retry_original_partition"
+	headerRetryOffset    = "retry_original_offset"
+	headerRetryAttempts  = "retry_attempts"
+)
+
+// origin describes where a message was consumed for the first time, before
+// it went through retry topics.
+type origin struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Attempts  int
+	// Tier is the index of the retry topic the message was consumed from,
+	// or -1 for the original topic.
+	Tier int
+}
+
+func (s *Service) origin(m kafka.Message) origin {
+	o := origin{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Tier: -1}
+
+	source, tier, ok := s.retryTier(m.Topic)
+	if !ok {
+		return o
+	}
+	o.Topic, o.Tier = source, tier
+
+	for _, h := range m.Headers {
+		switch h.Key {
+		case headerRetryPartition:
+			o.Partition, _ = strconv.Atoi(string(h.Value))
+		case headerRetryOffset:
+			o.Offset, _ = strconv.ParseInt(string(h.Value), 10, 64)
+		case headerRetryAttempts:
+			o.Attempts, _ = strconv.Atoi(string(h.Value))
+		}
+	}
+
+	return o
+}
+
+// retryTier finds the original topic of a retry topic and the index of the
+// retry topic among its tiers.
+func (s *Service) retryTier(topic string) (string, int, bool) {
+	for source, tiers := range s.RetryTopics {
+		for i, t := range tiers {
+			if t == topic {
+				return source, i, true
+			}
+		}
+	}
+
+	return "", 0, false
+}
+
+// retry produces the failed message to the retry topic, from which it is
+// consumed again once the topic's delay has passed.
+func (s *Service) retry(ctx context.Context, m kafka.Message, o origin, topic string) error {
+	log.Debug().
+		Str("topic", m.Topic).
+		Str("retry_topic", topic).
+		Int64("offset", m.Offset).
+		Int("attempts", o.Attempts).
+		Msg("send message to retry topic")
+
+	_, err := s.KafkaSender.Send(ctx, kafka.Message{
+		Topic: topic,
+		Key:   m.Key,
+		Value: m.Value,
+		Headers: replaceHeaders(m.Headers, map[string]string{
+			headerRetryTopic:     o.Topic,
+			headerRetryPartition: strconv.Itoa(o.Partition),
+			headerRetryOffset:    strconv.FormatInt(o.Offset, 10),
+			headerRetryAttempts:  strconv.Itoa(o.Attempts),
+		}),
+	})
+	if err != nil {
+		return fmt.Errorf("send message to retry topic %q error: %w", topic, err)
+	}
+
+	return nil
+}
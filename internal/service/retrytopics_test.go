@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+type testSender struct {
+	messages []kafka.Message
+}
+
+func (ts *testSender) Send(_ context.Context, m kafka.Message) (kafka.Message, error) {
+	ts.messages = append(ts.messages, m)
+	return m, nil
+}
+
+func header(m kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestHandleFailure(t *testing.T) {
+	sender := &testSender{}
+	s := &Service{
+		KafkaSender:      sender,
+		DeadLetterTopics: map[string]string{"orders": "orders.dlq"},
+		RetryTopics:      map[string][]string{"orders": {"orders.retry.5s", "orders.retry.1m"}},
+	}
+
+	m := kafka.Message{Topic: "orders", Partition: 2, Offset: 42, Key: []byte("k"), Value: []byte("v")}
+	cause := &processingError{stageRemote, 3, errors.New("boom")}
+
+	for _, expected := range []string{"orders.retry.5s", "orders.retry.1m", "orders.dlq"} {
+		sent, err := s.handleFailure(context.Background(), m, cause)
+		require.NoError(t, err)
+		require.True(t, sent)
+
+		m = sender.messages[len(sender.messages)-1]
+		require.Equal(t, expected, m.Topic)
+		require.Equal(t, []byte("k"), m.Key)
+		require.Equal(t, []byte("v"), m.Value)
+	}
+
+	require.Equal(t, "orders", header(m, headerSourceTopic))
+	require.Equal(t, "2", header(m, headerSourcePartition))
+	require.Equal(t, "42", header(m, headerSourceOffset))
+	require.Equal(t, "9", header(m, headerAttempts))
+	require.Equal(t, stageRemote, header(m, headerErrorStage))
+	require.Equal(t, "boom", header(m, headerErrorMessage))
+}
+
+func TestHandleFailureNotConfigured(t *testing.T) {
+	s := &Service{KafkaSender: &testSender{}}
+
+	sent, err := s.handleFailure(context.Background(), kafka.Message{Topic: "orders"}, errors.New("boom"))
+	require.NoError(t, err)
+	require.False(t, sent)
+}
@@ -3,9 +3,11 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"kafka-sidecar/internal/config"
 	"kafka-sidecar/internal/helpers"
+	"kafka-sidecar/internal/models"
+	"net/http"
 
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/kafka-go"
@@ -14,52 +16,234 @@ import (
 type sendMessage struct {
 	Topic   string            `json:"topic"`
 	Headers map[string]string `json:"headers"`
-	Key     string            `json:"key"`
-	Value   json.RawMessage   `json:"value"`
+	Key     json.RawMessage   `json:"key"`
+	// Value is produced as a tombstone if it is null.
+	Value json.RawMessage `json:"value"`
+	// Record is the full name of the value record, required by topics with
+	// a record name strategy.
+	Record string `json:"record"`
+	// Schema is an inline Avro schema to register and encode the value with,
+	// for topics with schema auto-registration.
+	Schema json.RawMessage `json:"schema"`
+	// SchemaID or else SchemaVersion pins the schema the value is encoded
+	// with.
+	SchemaID      int `json:"schema_id"`
+	SchemaVersion int `json:"schema_version"`
 }
 
-func (s *Service) send(ctx context.Context, msg []sendMessage) error {
+// sendError is a failure to produce a message along with the HTTP status
+// code that describes it.
+type sendError struct {
+	Status int
+	Err    error
+}
+
+func (e *sendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.Err
+}
+
+// sendResult is the outcome of producing a single message, reported to the
+// HTTP ingress client.
+type sendResult struct {
+	Topic     string           `json:"topic"`
+	Partition *int             `json:"partition,omitempty"`
+	Offset    *int64           `json:"offset,omitempty"`
+	Error     *sendResultError `json:"error,omitempty"`
+}
+
+type sendResultError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (s *Service) encodeMessage(re sendMessage) (kafka.Message, error) {
+	if len(s.AllowedTopics) > 0 && !helpers.InArrayString(s.AllowedTopics, re.Topic) {
+		return kafka.Message{}, &sendError{http.StatusForbidden, fmt.Errorf(
+			"topic %q is not allowed",
+			re.Topic,
+		)}
+	}
+
+	m := kafka.Message{
+		Topic: re.Topic,
+	}
+	for s2, s3 := range re.Headers {
+		m.Headers = append(m.Headers, kafka.Header{Key: s2, Value: []byte(s3)})
+	}
+
 	var err error
+	m.Key, err = s.SchemaRegistry.EncodeKey(re.Topic, re.Key)
+	if err != nil {
+		return kafka.Message{}, &sendError{encodeStatus(err), fmt.Errorf(
+			"pack key error for topic %s: key: %s, error: %w",
+			re.Topic,
+			string(re.Key),
+			err,
+		)}
+	}
+
+	if string(re.Value) == "null" {
+		return m, nil
+	}
+
+	m.Value, err = s.SchemaRegistry.Encode(re.Topic, re.Value, models.SchemaHint{
+		Record:  re.Record,
+		Schema:  string(re.Schema),
+		ID:      re.SchemaID,
+		Version: re.SchemaVersion,
+	})
+	if err != nil {
+		log.Debug().
+			Str("topic", m.Topic).
+			Any("headers", m.Headers).
+			Bytes("value", m.Value).
+			Msg("send message error")
+		return kafka.Message{}, &sendError{encodeStatus(err), fmt.Errorf(
+			"pack message error for topic %s: value: %v, error: %w",
+			re.Topic,
+			string(re.Value),
+			err,
+		)}
+	}
+
+	return m, nil
+}
+
+// encodeStatus is 503 if encoding failed because the schema registry is
+// unavailable, and 400 if the message cannot be encoded.
+func encodeStatus(err error) int {
+	if errors.Is(err, models.ErrSchemaRegistryUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusBadRequest
+}
+
+func (s *Service) sendMessage(ctx context.Context, m kafka.Message) (kafka.Message, error) {
+	log.Debug().
+		Str("topic", m.Topic).
+		Str("key", string(m.Key)).
+		Msg("send message")
+
+	m, err := s.KafkaSender.Send(ctx, m)
+	if err != nil {
+		return m, &sendError{http.StatusServiceUnavailable, fmt.Errorf("send message error: %w", err)}
+	}
 
+	return m, nil
+}
+
+// send produces the messages only if all of them can be encoded.
+func (s *Service) send(ctx context.Context, msg []sendMessage) error {
 	kafkaMessages := make([]kafka.Message, len(msg))
 	for i, re := range msg {
-		if len(config.Config.AllowedTopics) > 0 && !helpers.InArrayString(config.Config.AllowedTopics, re.Topic) {
-			return fmt.Errorf(
-				"topic %q is not allowed",
-				re.Topic,
-			)
+		var err error
+		if kafkaMessages[i], err = s.encodeMessage(re); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range kafkaMessages {
+		if _, err := s.sendMessage(ctx, m); err != nil {
+			return err
 		}
-		kafkaMessages[i].Topic = re.Topic
-		kafkaMessages[i].Key = []byte(re.Key)
-		for s2, s3 := range re.Headers {
-			kafkaMessages[i].Headers = append(kafkaMessages[i].Headers, kafka.Header{Key: s2, Value: []byte(s3)})
+	}
+
+	return nil
+}
+
+// sendEach produces every message that can be encoded and reports the
+// outcome of each one. The status is 201 if all messages were produced, and
+// the status of the first failure otherwise.
+func (s *Service) sendEach(ctx context.Context, msg []sendMessage) (int, []sendResult) {
+	status := http.StatusCreated
+	results := make([]sendResult, len(msg))
+	for i, re := range msg {
+		results[i].Topic = re.Topic
+
+		m, err := s.encodeMessage(re)
+		if err == nil {
+			m, err = s.sendMessage(ctx, m)
 		}
-		kafkaMessages[i].Value, err = s.SchemaRegistry.Encode(re.Topic, re.Value)
 		if err != nil {
-			log.Debug().
-				Str("topic", kafkaMessages[i].Topic).
-				Any("headers", kafkaMessages[i].Headers).
-				Bytes("value", kafkaMessages[i].Value).
-				Msg("send message error")
-			return fmt.Errorf(
-				"pack message error for topic %s: value: %v, error: %w",
-				re.Topic,
-				string(re.Value),
-				err,
-			)
+			log.Error().Err(err).Msg("http server processing error")
+			e := &sendResultError{http.StatusInternalServerError, err.Error()}
+			if sErr, ok := err.(*sendError); ok {
+				e.Status = sErr.Status
+			}
+			if status == http.StatusCreated {
+				status = e.Status
+			}
+			results[i].Error = e
+			continue
 		}
+
+		results[i].Partition, results[i].Offset = &m.Partition, &m.Offset
 	}
 
-	for _, m := range kafkaMessages {
-		log.Debug().
-			Str("topic", m.Topic).
-			Str("key", string(m.Key)).
-			Msg("send message")
+	return status, results
+}
+
+// validateResult is the outcome of validating a message against the schema
+// of its topic, reported to the HTTP ingress client.
+type validateResult struct {
+	Topic string `json:"topic"`
+	Valid bool   `json:"valid"`
+	// Value is the value as it would be produced and consumed.
+	Value  json.RawMessage     `json:"value,omitempty"`
+	Errors []models.FieldError `json:"errors,omitempty"`
+	// Warnings are problems that do not prevent producing the value, like
+	// fields that are dropped.
+	Warnings []models.FieldError `json:"warnings,omitempty"`
+}
 
-		if err := s.KafkaSender.Send(ctx, m); err != nil {
-			return fmt.Errorf("send message error: %w", err)
+// validateProcessing encodes the value of the message like send does
+// without producing it. The status is 200 if the value is valid and 422 if
+// it is not.
+func (s *Service) validateProcessing(topic string, body []byte) (int, any) {
+	if len(s.AllowedTopics) > 0 && !helpers.InArrayString(s.AllowedTopics, topic) {
+		return http.StatusForbidden, map[string]string{
+			"message": fmt.Sprintf("topic %q is not allowed", topic),
 		}
 	}
 
-	return nil
+	var re sendMessage
+	if err := json.Unmarshal(body, &re); err != nil {
+		return http.StatusBadRequest, map[string]string{
+			"message": fmt.Errorf("unmarshal message error for data: %s, error: %w", string(body), err).Error(),
+		}
+	}
+
+	result := validateResult{Topic: topic, Valid: true, Value: json.RawMessage("null")}
+	if string(re.Value) == "null" {
+		return http.StatusOK, result
+	}
+
+	validation, err := s.SchemaRegistry.Validate(topic, re.Value, models.SchemaHint{
+		Record:  re.Record,
+		Schema:  string(re.Schema),
+		ID:      re.SchemaID,
+		Version: re.SchemaVersion,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("validate message error")
+		status := http.StatusInternalServerError
+		if errors.Is(err, models.ErrSchemaRegistryUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		return status, map[string]string{
+			"message": fmt.Errorf("validate message error for topic %s: %w", topic, err).Error(),
+		}
+	}
+	if len(validation.Errors) > 0 {
+		return http.StatusUnprocessableEntity, validateResult{Topic: topic, Errors: validation.Errors, Warnings: validation.Warnings}
+	}
+
+	result.Value = validation.Value
+	result.Warnings = validation.Warnings
+	return http.StatusOK, result
 }
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kafka-sidecar/internal/models"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry passes keys and values through unchanged.
+type testRegistry struct{}
+
+func (testRegistry) Encode(_ string, value []byte, _ models.SchemaHint) ([]byte, error) {
+	return value, nil
+}
+
+func (testRegistry) Decode(_ string, value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func (testRegistry) Validate(_ string, value []byte, _ models.SchemaHint) (models.Validation, error) {
+	return models.Validation{Value: value}, nil
+}
+
+func (testRegistry) EncodeKey(_ string, key []byte) ([]byte, error) {
+	return key, nil
+}
+
+func (testRegistry) DecodeKey(_ string, key []byte) ([]byte, error) {
+	return key, nil
+}
+
+// unavailableRegistry fails every value like an unreachable schema registry.
+type unavailableRegistry struct {
+	testRegistry
+}
+
+func (unavailableRegistry) Encode(_ string, _ []byte, _ models.SchemaHint) ([]byte, error) {
+	return nil, fmt.Errorf("get schema error: %w", models.ErrSchemaRegistryUnavailable)
+}
+
+func (unavailableRegistry) Validate(_ string, _ []byte, _ models.SchemaHint) (models.Validation, error) {
+	return models.Validation{}, fmt.Errorf("get schema error: %w", models.ErrSchemaRegistryUnavailable)
+}
+
+// brokenRegistry fails to validate every value with an internal error.
+type brokenRegistry struct {
+	testRegistry
+}
+
+func (brokenRegistry) Validate(_ string, _ []byte, _ models.SchemaHint) (models.Validation, error) {
+	return models.Validation{}, errors.New("decode encoded value error")
+}
+
+type testRemote struct {
+	values [][]byte
+	resp   []byte
+}
+
+func (tr *testRemote) Send(_ context.Context, _ string, _ map[string]string, _, value []byte, _ time.Time, _ int64) ([]byte, error) {
+	tr.values = append(tr.values, value)
+	return tr.resp, nil
+}
+
+func TestTombstone(t *testing.T) {
+	sender := &testSender{}
+	remote := &testRemote{resp: []byte(`[{"topic": "users.compacted", "key": "k", "value": null}]`)}
+	s := &Service{
+		KafkaSender:    sender,
+		SchemaRegistry: testRegistry{},
+		RemoteServer:   remote,
+	}
+
+	err := s.kafkaProcessing(context.Background(), kafka.Message{Topic: "users", Key: []byte(`"k"`)})
+	require.NoError(t, err)
+
+	require.Equal(t, [][]byte{nil}, remote.values)
+	require.Len(t, sender.messages, 1)
+	require.Equal(t, "users.compacted", sender.messages[0].Topic)
+	require.Equal(t, []byte(`"k"`), sender.messages[0].Key)
+	require.Nil(t, sender.messages[0].Value)
+}
+
+func TestEncodeMessageStatus(t *testing.T) {
+	s := &Service{SchemaRegistry: unavailableRegistry{}}
+
+	_, err := s.encodeMessage(sendMessage{Topic: "users", Key: []byte(`"k"`), Value: []byte(`{}`)})
+	var sErr *sendError
+	require.ErrorAs(t, err, &sErr)
+	require.Equal(t, http.StatusServiceUnavailable, sErr.Status)
+}
+
+func TestValidate(t *testing.T) {
+	s := &Service{SchemaRegistry: testRegistry{}, AllowedTopics: []string{"users"}}
+
+	status, body := s.validateProcessing("users", []byte(`{"value": {"id": "u1"}}`))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, validateResult{Topic: "users", Valid: true, Value: json.RawMessage(`{"id": "u1"}`)}, body)
+
+	status, _ = s.validateProcessing("orders", []byte(`{"value": {}}`))
+	require.Equal(t, http.StatusForbidden, status)
+
+	status, _ = s.validateProcessing("users", []byte(`[]`))
+	require.Equal(t, http.StatusBadRequest, status)
+
+	s.SchemaRegistry = unavailableRegistry{}
+	status, _ = s.validateProcessing("users", []byte(`{"value": {"id": "u1"}}`))
+	require.Equal(t, http.StatusServiceUnavailable, status)
+
+	s.SchemaRegistry = brokenRegistry{}
+	status, _ = s.validateProcessing("users", []byte(`{"value": {"id": "u1"}}`))
+	require.Equal(t, http.StatusInternalServerError, status)
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"kafka-sidecar/internal/metrics"
+	"kafka-sidecar/internal/models"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -14,16 +17,33 @@ import (
 )
 
 type SchemaRegistry interface {
-	Encode(topic string, value []byte) ([]byte, error)
+	Encode(topic string, value []byte, hint models.SchemaHint) ([]byte, error)
 	Decode(topic string, value []byte) ([]byte, error)
+	// Validate returns the value as Encode would produce it and it would be
+	// consumed, or the errors of the fields that prevent encoding it.
+	Validate(topic string, value []byte, hint models.SchemaHint) (models.Validation, error)
+	// EncodeKey and DecodeKey convert between a JSON key and the key format
+	// of the topic.
+	EncodeKey(topic string, key []byte) ([]byte, error)
+	DecodeKey(topic string, key []byte) ([]byte, error)
 }
 
+// RemoteServer receives the key and the value as JSON. A nil value is a
+// tombstone.
 type RemoteServer interface {
 	Send(ctx context.Context, topic string, headers map[string]string, key, value []byte, timestamp time.Time, offset int64) ([]byte, error)
 }
 
+// HttpServer calls handler for every produce request and validate for every
+// validation request of a topic, and replies with the status code and the
+// JSON body they return.
 type HttpServer interface {
-	Listen(ctx context.Context) (<-chan []byte, <-chan error)
+	Listen(ctx context.Context, handler func(body []byte) (int, any), validate func(topic string, body []byte) (int, any)) <-chan error
+}
+
+// AppHealth blocks until the application is ready to receive messages.
+type AppHealth interface {
+	Wait(ctx context.Context) error
 }
 
 type KafkaListener interface {
@@ -31,8 +51,10 @@ type KafkaListener interface {
 	CommitMessage(ctx context.Context, m kafka.Message) error
 }
 
+// KafkaSender returns the message with the partition and offset it was
+// written to.
 type KafkaSender interface {
-	Send(ctx context.Context, m kafka.Message) error
+	Send(ctx context.Context, m kafka.Message) (kafka.Message, error)
 }
 
 type Service struct {
@@ -41,20 +63,66 @@ type Service struct {
 	HttpServer       HttpServer
 	SchemaRegistry   SchemaRegistry
 	RemoteServer     RemoteServer
+	AppHealth        AppHealth
+	AllowedTopics    []string
 	CommitOnSuccess  bool
 	TerminateOnError bool
+	DeadLetterTopics map[string]string
+	RetryPolicy      RetryPolicy
+	// RetryTopics maps a consumed topic to its retry topics, ordered by delay.
+	RetryTopics map[string][]string
+	// Workers is the number of messages processed concurrently. Messages of
+	// the same partition, or of the same key if OrderByKey is set, are always
+	// processed in order by the same worker.
+	Workers    int
+	OrderByKey bool
+	// DrainTimeout limits how long in-flight messages are processed after
+	// ctx is done.
+	DrainTimeout time.Duration
 }
 
+// Run listens until ctx is done. Messages received before that are still
+// processed and committed, for at most DrainTimeout, before Run returns.
 func (s *Service) Run(ctx context.Context) {
+	procCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 
+	drained := make(chan struct{})
+	defer close(drained)
+	go func() {
+		select {
+		case <-drained:
+			return
+		case <-ctx.Done():
+		}
+
+		log.Info().Dur("timeout", s.DrainTimeout).Msg("draining in-flight messages")
+		timer := time.NewTimer(s.DrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-drained:
+		case <-timer.C:
+			log.Warn().Msg("drain timeout exceeded, cancelling in-flight messages")
+			cancel()
+		}
+	}()
+
 	go func() {
 		defer wg.Done()
 		if s.KafkaListener == nil {
 			return
 		}
 
+		if s.AppHealth != nil {
+			log.Info().Msg("waiting for app to become healthy")
+			if err := s.AppHealth.Wait(ctx); err != nil {
+				return
+			}
+		}
+
 		messageCh, errorCh := s.KafkaListener.Listen(ctx)
 
 		go func() {
@@ -66,32 +134,7 @@ func (s *Service) Run(ctx context.Context) {
 			}
 		}()
 
-		for m := range messageCh {
-			log.Debug().
-				Str("topic", m.Topic).
-				Str("key", string(m.Key)).
-				Time("timestamp", m.Time).
-				Int64("offset", m.Offset).
-				Msg("new message from kafka")
-
-			needExit := false
-			err := s.kafkaProcessing(ctx, m)
-			if err != nil {
-				log.Error().Err(err).Msg("kafka processing error")
-				needExit = s.TerminateOnError
-			}
-			if err == nil && s.CommitOnSuccess {
-				log.Debug().Msgf("Committing message with offset: %d", m.Offset)
-				if err = s.KafkaListener.CommitMessage(ctx, m); err != nil {
-					log.Error().Err(err).Msg("commit error")
-					needExit = needExit || s.TerminateOnError
-				}
-			}
-
-			if needExit {
-				os.Exit(1)
-			}
-		}
+		s.dispatch(procCtx, messageCh)
 	}()
 
 	go func() {
@@ -100,25 +143,17 @@ func (s *Service) Run(ctx context.Context) {
 			return
 		}
 
-		messageCh, errorCh := s.HttpServer.Listen(ctx)
-
-		go func() {
-			for err := range errorCh {
-				log.Error().Err(err).Msg("kafka listen error")
-				if s.TerminateOnError {
-					os.Exit(1)
-				}
-			}
-		}()
-
-		for m := range messageCh {
+		errorCh := s.HttpServer.Listen(ctx, func(body []byte) (int, any) {
 			log.Debug().
-				Bytes("message", m).
+				Bytes("message", body).
 				Msg("new message from http")
 
-			err := s.httpServerProcessing(ctx, m)
-			if err != nil {
-				log.Error().Err(err).Msg("http server processing error")
+			return s.httpServerProcessing(procCtx, body)
+		}, s.validateProcessing)
+
+		for err := range errorCh {
+			log.Error().Err(err).Msg("http server error")
+			if s.TerminateOnError {
 				os.Exit(1)
 			}
 		}
@@ -127,15 +162,73 @@ func (s *Service) Run(ctx context.Context) {
 	wg.Wait()
 }
 
+// processKafkaMessage runs kafkaProcessing and the failure handling for the
+// message. It returns whether the message may be committed.
+func (s *Service) processKafkaMessage(ctx context.Context, m kafka.Message) bool {
+	log.Debug().
+		Str("topic", m.Topic).
+		Str("key", string(m.Key)).
+		Time("timestamp", m.Time).
+		Int64("offset", m.Offset).
+		Msg("new message from kafka")
+	metrics.MessagesConsumed.WithLabelValues(m.Topic).Inc()
+
+	if s.AppHealth != nil {
+		if err := s.AppHealth.Wait(ctx); err != nil {
+			log.Error().Err(err).Msg("wait for app error")
+			return false
+		}
+	}
+
+	err := s.kafkaProcessing(ctx, m)
+	if err == nil {
+		return true
+	}
+	if ctx.Err() != nil {
+		// cancelled by the drain timeout, the message is consumed again
+		// after the restart
+		log.Warn().Err(err).Int64("offset", m.Offset).Msg("kafka processing cancelled")
+		return false
+	}
+
+	log.Error().Err(err).Msg("kafka processing error")
+	metrics.MessagesFailed.WithLabelValues(m.Topic, errorStage(err)).Inc()
+	sent, fErr := s.handleFailure(ctx, m, err)
+	if fErr != nil {
+		log.Error().Err(fErr).Msg("failed message handling error")
+	}
+	if !sent && s.TerminateOnError {
+		os.Exit(1)
+	}
+
+	return sent
+}
+
 func (s *Service) kafkaProcessing(ctx context.Context, msg kafka.Message) error {
-	value, err := s.SchemaRegistry.Decode(msg.Topic, msg.Value)
+	topic := s.origin(msg).Topic
+
+	key, err := s.SchemaRegistry.DecodeKey(topic, msg.Key)
 	if err != nil {
-		return fmt.Errorf(
-			"failed to decode message from topic %s: raw_value: %v, error: %w",
-			msg.Topic,
-			string(msg.Value),
+		return &processingError{stageDecode, 1, fmt.Errorf(
+			"failed to decode key from topic %s: raw_key: %v, error: %w",
+			topic,
+			string(msg.Key),
 			err,
-		)
+		)}
+	}
+
+	// a tombstone is delivered as a nil value
+	var value []byte
+	if msg.Value != nil {
+		value, err = s.SchemaRegistry.Decode(topic, msg.Value)
+		if err != nil {
+			return &processingError{stageDecode, 1, fmt.Errorf(
+				"failed to decode message from topic %s: raw_value: %v, error: %w",
+				topic,
+				string(msg.Value),
+				err,
+			)}
+		}
 	}
 
 	headers := make(map[string]string, len(msg.Headers))
@@ -143,55 +236,73 @@ func (s *Service) kafkaProcessing(ctx context.Context, msg kafka.Message) error
 		headers[h.Key] = string(h.Value)
 	}
 
-	data, err := s.RemoteServer.Send(
-		ctx,
-		msg.Topic,
-		headers,
-		msg.Key,
-		value,
-		msg.Time,
-		msg.Offset,
-	)
+	var data []byte
+	attempts, err := s.RetryPolicy.withRetry(ctx, func() error {
+		data, err = s.RemoteServer.Send(
+			ctx,
+			topic,
+			headers,
+			key,
+			value,
+			msg.Time,
+			msg.Offset,
+		)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf(
-			"request to remote server error for topic %s: key: %v, value: %v, error: %w",
-			msg.Topic,
-			msg.Key,
+		return &processingError{stageRemote, attempts, fmt.Errorf(
+			"request to remote server error for topic %s: key: %s, value: %s, error: %w",
+			topic,
+			key,
 			value,
 			err,
-		)
+		)}
 	}
 
 	var res []sendMessage
 
 	if err := json.Unmarshal(data, &res); err != nil {
-		return fmt.Errorf(
+		return &processingError{stageUnmarshal, attempts, fmt.Errorf(
 			"unmarshal response error for data: %v, error: %w",
 			data,
 			err,
-		)
+		)}
 	}
 
 	for i := range res {
 		if res[i].Headers == nil {
 			res[i].Headers = map[string]string{}
 		}
-		res[i].Headers["processed_topic"] = msg.Topic
+		res[i].Headers["processed_topic"] = topic
+	}
+
+	if err := s.send(ctx, res); err != nil {
+		return &processingError{stageProduce, attempts, err}
 	}
 
-	return s.send(ctx, res)
+	return nil
 }
 
-func (s *Service) httpServerProcessing(ctx context.Context, msg []byte) error {
+// httpServerProcessing produces the messages of an HTTP ingress request and
+// returns the response status code and body.
+func (s *Service) httpServerProcessing(ctx context.Context, msg []byte) (int, any) {
 	var res []sendMessage
 
 	if err := json.Unmarshal(msg, &res); err != nil {
-		return fmt.Errorf(
+		err = fmt.Errorf(
 			"unmarshal message error for data: %s, error: %w",
 			string(msg),
 			err,
 		)
+		log.Error().Err(err).Msg("http server processing error")
+		return http.StatusBadRequest, map[string]string{
+			"message": err.Error(),
+		}
 	}
 
-	return s.send(ctx, res)
+	status, results := s.sendEach(ctx, res)
+
+	return status, map[string]any{
+		"results": results,
+	}
 }
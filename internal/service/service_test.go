@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"kafka-sidecar/internal/metrics"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingRemote blocks every request until its context is done.
+type blockingRemote struct{}
+
+func (blockingRemote) Send(ctx context.Context, _ string, _ map[string]string, _, _ []byte, _ time.Time, _ int64) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestProcessKafkaMessageCancelled(t *testing.T) {
+	sender := &testSender{}
+	s := &Service{
+		KafkaSender:      sender,
+		SchemaRegistry:   testRegistry{},
+		RemoteServer:     blockingRemote{},
+		TerminateOnError: true,
+		DeadLetterTopics: map[string]string{"drained": "drained.dlq"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	failed := testutil.ToFloat64(metrics.MessagesFailed.WithLabelValues("drained", stageRemote))
+	ok := s.processKafkaMessage(ctx, kafka.Message{Topic: "drained", Key: []byte(`"k"`), Value: []byte(`{}`)})
+	require.False(t, ok)
+	require.Empty(t, sender.messages)
+	require.Equal(t, failed, testutil.ToFloat64(metrics.MessagesFailed.WithLabelValues("drained", stageRemote)))
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"hash/fnv"
+	"kafka-sidecar/internal/metrics"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+// workerQueueSize is the number of messages a busy worker may have queued
+// before the dispatcher blocks.
+const workerQueueSize = 16
+
+// dispatch distributes the messages among the workers and commits offsets as
+// the messages complete. It returns when messageCh is closed and every
+// dispatched message is processed.
+func (s *Service) dispatch(ctx context.Context, messageCh <-chan kafka.Message) {
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	tracker := newOffsetTracker()
+	queues := make([]chan *trackedMessage, workers)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := range queues {
+		queues[i] = make(chan *trackedMessage, workerQueueSize)
+		go func(queue <-chan *trackedMessage) {
+			defer wg.Done()
+			for tm := range queue {
+				ok := s.processKafkaMessage(ctx, tm.msg)
+				if !s.CommitOnSuccess {
+					continue
+				}
+				stoppedAt, err := tracker.complete(tm, ok, func(m kafka.Message, n int) error {
+					log.Debug().Msgf("Committing message with offset: %d", m.Offset)
+					if err := s.KafkaListener.CommitMessage(ctx, m); err != nil {
+						return err
+					}
+					metrics.MessagesCommitted.WithLabelValues(m.Topic).Add(float64(n))
+					return nil
+				})
+				if stoppedAt != nil {
+					log.Error().
+						Str("topic", stoppedAt.Topic).
+						Int("partition", stoppedAt.Partition).
+						Int64("offset", stoppedAt.Offset).
+						Msg("partition stopped at a failed message until restart")
+					metrics.PartitionStopped.WithLabelValues(stoppedAt.Topic, strconv.Itoa(stoppedAt.Partition)).Set(1)
+				}
+				if err != nil {
+					log.Error().Err(err).Msg("commit error")
+					if s.TerminateOnError {
+						os.Exit(1)
+					}
+				}
+			}
+		}(queues[i])
+	}
+
+	for m := range messageCh {
+		// offsets are only tracked for committing on success
+		tm := &trackedMessage{msg: m}
+		if s.CommitOnSuccess {
+			if tm = tracker.add(m); tm == nil {
+				// its partition is stopped, the message is consumed again
+				// after a restart
+				continue
+			}
+		}
+		queues[s.workerIndex(m, workers)] <- tm
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+}
+
+func (s *Service) workerIndex(m kafka.Message, workers int) int {
+	if workers == 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(m.Topic))
+	if s.OrderByKey {
+		_, _ = h.Write(m.Key)
+	} else {
+		_, _ = h.Write([]byte(strconv.Itoa(m.Partition)))
+	}
+
+	return int(h.Sum32() % uint32(workers))
+}
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+type trackedMessage struct {
+	msg       kafka.Message
+	partition *partitionOffsets
+	done      bool
+	ok        bool
+}
+
+// partitionOffsets keeps the in-flight messages of a partition in the order
+// they were fetched.
+type partitionOffsets struct {
+	mu      sync.Mutex
+	pending []*trackedMessage
+	// stopped is set once a failed message is the first pending message.
+	stopped bool
+}
+
+// offsetTracker commits a partition only up to the lowest contiguous
+// completed offset, so that no message is committed before every message
+// fetched ahead of it is completed.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: map[topicPartition]*partitionOffsets{},
+	}
+}
+
+// add tracks the message, or returns nil if its partition is stopped.
+func (t *offsetTracker) add(m kafka.Message) *trackedMessage {
+	t.mu.Lock()
+	key := topicPartition{m.Topic, m.Partition}
+	p := t.partitions[key]
+	if p == nil {
+		p = &partitionOffsets{}
+		t.partitions[key] = p
+	}
+	t.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return nil
+	}
+	tm := &trackedMessage{msg: m, partition: p}
+	p.pending = append(p.pending, tm)
+
+	return tm
+}
+
+// complete marks the message as processed and commits the last message of
+// the prefix of its partition that was processed successfully, along with
+// the number of messages the commit covers. Commits of a partition are
+// serialized, so offsets never move backwards.
+//
+// Nothing fetched after a failed message can be committed, so once a failed
+// message heads its partition the partition stops: its pending messages are
+// released, later messages are not tracked, and complete returns the failed
+// message. The partition is consumed again from there after a restart.
+func (t *offsetTracker) complete(tm *trackedMessage, ok bool, commit func(m kafka.Message, n int) error) (*kafka.Message, error) {
+	p := tm.partition
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return nil, nil
+	}
+	tm.done, tm.ok = true, ok
+
+	n := 0
+	for n < len(p.pending) && p.pending[n].done && p.pending[n].ok {
+		n++
+	}
+	var err error
+	if n > 0 {
+		last := p.pending[n-1]
+		p.pending = p.pending[n:]
+		err = commit(last.msg, n)
+	}
+
+	if len(p.pending) > 0 && p.pending[0].done {
+		failed := p.pending[0].msg
+		p.stopped = true
+		p.pending = nil
+		return &failed, err
+	}
+
+	return nil, err
+}
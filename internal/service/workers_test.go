@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	tracker := newOffsetTracker()
+
+	tracked := make([]*trackedMessage, 5)
+	for i := range tracked {
+		tracked[i] = tracker.add(kafka.Message{Topic: "orders", Partition: 0, Offset: int64(i)})
+	}
+	other := tracker.add(kafka.Message{Topic: "orders", Partition: 1, Offset: 100})
+
+	var committed []int64
+	commit := func(m kafka.Message, _ int) error {
+		committed = append(committed, m.Offset)
+		return nil
+	}
+
+	complete := func(tm *trackedMessage, ok bool) *kafka.Message {
+		stoppedAt, err := tracker.complete(tm, ok, commit)
+		require.NoError(t, err)
+		return stoppedAt
+	}
+
+	require.Nil(t, complete(tracked[2], true))
+	require.Nil(t, complete(tracked[1], true))
+	require.Empty(t, committed)
+
+	require.Nil(t, complete(tracked[0], true))
+	require.Equal(t, []int64{2}, committed)
+
+	require.Nil(t, complete(other, true))
+	require.Equal(t, []int64{2, 100}, committed)
+
+	// the failed message stops its partition, nothing after it is committed
+	// or tracked
+	require.Nil(t, complete(tracked[4], true))
+	stoppedAt := complete(tracked[3], false)
+	require.NotNil(t, stoppedAt)
+	require.Equal(t, int64(3), stoppedAt.Offset)
+	require.Equal(t, []int64{2, 100}, committed)
+	require.Nil(t, tracker.add(kafka.Message{Topic: "orders", Partition: 0, Offset: 5}))
+
+	next := tracker.add(kafka.Message{Topic: "orders", Partition: 1, Offset: 101})
+	require.Nil(t, complete(next, true))
+	require.Equal(t, []int64{2, 100, 101}, committed)
+}
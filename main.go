@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"kafka-sidecar/internal/adapters/adminServer"
+	"kafka-sidecar/internal/adapters/appHealth"
 	"kafka-sidecar/internal/adapters/httpServer"
 	"kafka-sidecar/internal/adapters/kafka"
 	"kafka-sidecar/internal/adapters/registry"
 	"kafka-sidecar/internal/adapters/remoteServer"
 	"kafka-sidecar/internal/config"
 	"kafka-sidecar/internal/service"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -21,36 +28,209 @@ func main() {
 		time.Sleep(time.Duration(config.Config.StartupDelay) * time.Second)
 	}
 
-	ctx, doneFunc := context.WithCancel(context.Background())
+	ctx, doneFunc := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
 	defer doneFunc()
+	go func() {
+		<-ctx.Done()
+		log.Info().Msg("shutting down")
+		// a second signal terminates the process immediately
+		doneFunc()
+	}()
 
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	if config.Config.Debug {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
-	kafkaInst := kafka.New(config.Config.KafkaBrokers, config.Config.KafkaTopics, config.Config.KafkaConsumerGroupId)
+	topics := append([]string{}, config.Config.KafkaTopics...)
+	retryTopics := map[string][]string{}
+	for _, topic := range config.Config.KafkaTopics {
+		for _, delay := range config.Config.RetryDelays {
+			retryTopics[topic] = append(retryTopics[topic], fmt.Sprintf("%s.retry.%s", topic, delay))
+		}
+		topics = append(topics, retryTopics[topic]...)
+	}
+
+	kafkaInst := kafka.New(config.Config.KafkaBrokers, topics, config.Config.KafkaConsumerGroupId)
+	for _, tiers := range retryTopics {
+		for i, topic := range tiers {
+			delay, _ := time.ParseDuration(config.Config.RetryDelays[i])
+			kafkaInst.SetDelay(topic, delay)
+		}
+	}
 	defer func() {
 		if err := kafkaInst.Close(); err != nil {
 			log.Error().Err(err).Msg("close kafka error")
 		}
 	}()
 
+	registryInst := registry.New(config.Config.SchemaRegistryUrl, config.Config.AvroSchemaRefreshInterval, registryOptions()...)
+	for topic, c := range topicConfigs() {
+		registryInst.SetTopicConfig(topic, c)
+	}
+
 	srv := &service.Service{
 		KafkaSender:      kafkaInst,
-		SchemaRegistry:   registry.New(config.Config.SchemaRegistryUrl, config.Config.AvroSchemaRefreshInterval),
+		SchemaRegistry:   registryInst,
 		RemoteServer:     remoteServer.New(config.Config.HttpRoute),
+		AllowedTopics:    config.Config.AllowedTopics,
 		CommitOnSuccess:  config.Config.CommitOnSuccess,
 		TerminateOnError: config.Config.TerminateOnError,
+		DeadLetterTopics: config.Config.DeadLetterTopics,
+		RetryPolicy: service.RetryPolicy{
+			MaxAttempts:    config.Config.RemoteRetryMaxAttempts,
+			InitialBackoff: time.Duration(config.Config.RemoteRetryInitialBackoff) * time.Millisecond,
+			MaxBackoff:     time.Duration(config.Config.RemoteRetryMaxBackoff) * time.Millisecond,
+			Jitter:         config.Config.RemoteRetryJitter,
+			StatusCodes:    config.Config.RemoteRetryStatusCodes,
+		},
+		RetryTopics: retryTopics,
+		Workers:     config.Config.Workers,
+		OrderByKey:  config.Config.Ordering == "key",
+
+		DrainTimeout: time.Duration(config.Config.ShutdownTimeout) * time.Second,
 	}
 
 	if len(config.Config.KafkaTopics) > 0 {
 		srv.KafkaListener = kafkaInst
 	}
 
+	var app *appHealth.AppHealth
+	if len(config.Config.AppHealthUrl) > 0 {
+		app = appHealth.New(config.Config.AppHealthUrl, time.Duration(config.Config.AppHealthTimeout)*time.Second)
+	}
+	if config.Config.WaitForApp {
+		go app.Watch(
+			ctx,
+			time.Duration(config.Config.AppHealthInterval)*time.Second,
+			time.Duration(config.Config.AppUnhealthyThreshold)*time.Second,
+		)
+		srv.AppHealth = app
+	}
+
 	if config.Config.HttpPort > 0 {
-		srv.HttpServer = httpServer.New(config.Config.HttpPort)
+		srv.HttpServer = httpServer.New(config.Config.HttpPort, time.Duration(config.Config.ShutdownTimeout)*time.Second)
+	}
+
+	if config.Config.AdminPort > 0 {
+		admin := adminServer.New(config.Config.AdminPort, time.Duration(config.Config.ShutdownTimeout)*time.Second)
+		admin.AddCheck("kafka", kafkaInst.Ping)
+		admin.AddCheck("schema_registry", registryInst.Ping)
+		if srv.KafkaListener != nil {
+			admin.AddCheck("consumer_group", kafkaInst.CheckGroup)
+		}
+		if app != nil {
+			admin.AddCheck("app", app.Check)
+		}
+
+		errorCh := admin.Listen(ctx)
+		go func() {
+			for err := range errorCh {
+				log.Error().Err(err).Msg("admin server error")
+			}
+		}()
 	}
 
 	srv.Run(ctx)
+	log.Info().Msg("service stopped")
+}
+
+// registryOptions collects the authentication settings of the schema
+// registry.
+func registryOptions() []registry.Option {
+	var opts []registry.Option
+	if len(config.Config.SchemaRegistryUsername) > 0 {
+		opts = append(opts, registry.WithBasicAuth(config.Config.SchemaRegistryUsername, config.Config.SchemaRegistryPassword))
+	}
+	if len(config.Config.SchemaRegistryTokenFile) > 0 {
+		opts = append(opts, registry.WithBearerTokenFile(config.Config.SchemaRegistryTokenFile))
+	}
+	if len(config.Config.SchemaRegistryCaFile) > 0 || len(config.Config.SchemaRegistryCertFile) > 0 {
+		tlsConfig, err := registry.LoadTLSConfig(
+			config.Config.SchemaRegistryCaFile,
+			config.Config.SchemaRegistryCertFile,
+			config.Config.SchemaRegistryKeyFile,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("load schema registry tls config error")
+		}
+		opts = append(opts, registry.WithTLSConfig(tlsConfig))
+	}
+
+	return opts
+}
+
+// topicConfigs collects the per-topic serialization settings.
+func topicConfigs() map[string]registry.TopicConfig {
+	configs := map[string]registry.TopicConfig{}
+	for topic, format := range config.Config.KeyFormats {
+		c := configs[topic]
+		c.KeyFormat = registry.Format(format)
+		configs[topic] = c
+	}
+	for topic, format := range config.Config.ValueFormats {
+		c := configs[topic]
+		c.ValueFormat = registry.Format(format)
+		configs[topic] = c
+	}
+	for topic, strategy := range config.Config.SubjectNameStrategies {
+		c := configs[topic]
+		c.SubjectNameStrategy = registry.SubjectNameStrategy(strategy)
+		configs[topic] = c
+	}
+	for _, topic := range config.Config.ValidateOnConsume {
+		c := configs[topic]
+		c.ValidateOnConsume = true
+		configs[topic] = c
+	}
+	for _, topic := range config.Config.PlainJson {
+		c := configs[topic]
+		c.PlainJSON = true
+		configs[topic] = c
+	}
+	for _, topic := range config.Config.StrictFields {
+		c := configs[topic]
+		c.StrictFields = true
+		configs[topic] = c
+	}
+	for _, topic := range config.Config.AutoRegisterSchemas {
+		c := configs[topic]
+		c.AutoRegister = true
+		configs[topic] = c
+	}
+	for topic, id := range config.Config.SchemaIds {
+		c := configs[topic]
+		c.SchemaID, _ = strconv.Atoi(id)
+		configs[topic] = c
+	}
+	for topic, version := range config.Config.SchemaVersions {
+		c := configs[topic]
+		c.SchemaVersion, _ = strconv.Atoi(version)
+		configs[topic] = c
+	}
+	for topic, version := range config.Config.ReaderSchemaVersions {
+		c := configs[topic]
+		c.ReaderSchemaVersion, _ = strconv.Atoi(version)
+		configs[topic] = c
+	}
+	for topic, path := range config.Config.ReaderSchemaFiles {
+		schema, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal().Err(err).Str("topic", topic).Msg("read reader schema file error")
+		}
+		c := configs[topic]
+		c.ReaderSchema = string(schema)
+		configs[topic] = c
+	}
+	for topic, path := range config.Config.SchemaFiles {
+		schema, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal().Err(err).Str("topic", topic).Msg("read schema file error")
+		}
+		c := configs[topic]
+		c.Schema = string(schema)
+		configs[topic] = c
+	}
+
+	return configs
 }